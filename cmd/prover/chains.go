@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+var ChainsConfig = cli.StringFlag{
+	Name: "chains.config",
+	Usage: "JSON file mapping each chain id to the flags of its prover backend, e.g. " +
+		`{"255": {"aws.prover-instance-id": "i-0123"}}. Flags which are not given are inherited. ` +
+		"The proofs of each chain are stored in <proof.base-dir>/<chain id> by default",
+	EnvVar: "CHAINS_CONFIG",
+}
+
+// flagValues reads the flag values. It is implemented by *cli.Context.
+type flagValues interface {
+	String(name string) string
+	StringSlice(name string) []string
+	Int(name string) int
+	Int64(name string) int64
+	Bool(name string) bool
+	Duration(name string) time.Duration
+}
+
+// chainFlags overrides the flag values of ctx for a chain. Slices are given as comma separated values.
+type chainFlags struct {
+	ctx       *cli.Context
+	overrides map[string]string
+}
+
+// loadChainFlags reads the flags of each chain from the config file.
+func loadChainFlags(ctx *cli.Context, path string) map[uint64]flagValues {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		log.Panicln(fmt.Errorf("failed to read chains config: %w", err))
+	}
+	var config map[string]map[string]string
+	if err := json.Unmarshal(file, &config); err != nil {
+		log.Panicln(fmt.Errorf("failed to parse chains config: %w", err))
+	}
+	if len(config) == 0 {
+		log.Panicf("no chain is configured in %s\n", path)
+	}
+	names := make(map[string]bool)
+	for _, flag := range AllFlags() {
+		names[flag.GetName()] = true
+	}
+	result := make(map[uint64]flagValues)
+	for key, overrides := range config {
+		chainId, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || chainId == 0 {
+			log.Panicf("invalid chain id %s in chains config\n", key)
+		}
+		for name := range overrides {
+			if !names[name] || name == ChainsConfig.Name {
+				log.Panicf("unknown flag %s of chain %d in chains config\n", name, chainId)
+			}
+		}
+		if _, ok := overrides[ProofBaseDir.Name]; !ok {
+			overrides[ProofBaseDir.Name] = filepath.Join(ctx.String(ProofBaseDir.Name), key)
+		}
+		result[chainId] = &chainFlags{ctx: ctx, overrides: overrides}
+	}
+	return result
+}
+
+func (f *chainFlags) String(name string) string {
+	if value, ok := f.overrides[name]; ok {
+		return value
+	}
+	return f.ctx.String(name)
+}
+
+func (f *chainFlags) StringSlice(name string) []string {
+	value, ok := f.overrides[name]
+	if !ok {
+		return f.ctx.StringSlice(name)
+	}
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (f *chainFlags) Int(name string) int {
+	if _, ok := f.overrides[name]; !ok {
+		return f.ctx.Int(name)
+	}
+	return int(f.Int64(name))
+}
+
+func (f *chainFlags) Int64(name string) int64 {
+	value, ok := f.overrides[name]
+	if !ok {
+		return f.ctx.Int64(name)
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Panicln(fmt.Errorf("invalid %s %s in chains config: %w", name, value, err))
+	}
+	return parsed
+}
+
+func (f *chainFlags) Bool(name string) bool {
+	value, ok := f.overrides[name]
+	if !ok {
+		return f.ctx.Bool(name)
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Panicln(fmt.Errorf("invalid %s %s in chains config: %w", name, value, err))
+	}
+	return parsed
+}
+
+func (f *chainFlags) Duration(name string) time.Duration {
+	value, ok := f.overrides[name]
+	if !ok {
+		return f.ctx.Duration(name)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Panicln(fmt.Errorf("invalid %s %s in chains config: %w", name, value, err))
+	}
+	return parsed
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kroma-network/kroma-prover-proxy/internal/proof"
+	"github.com/urfave/cli"
+)
+
+var (
+	ProxyUrl = cli.StringFlag{
+		Name:   "proxy.url",
+		Usage:  "Json Rpc url of the running proxy",
+		Value:  "http://localhost:6000",
+		EnvVar: "PROXY_URL",
+	}
+	ProveTrace = cli.StringFlag{
+		Name:  "trace",
+		Usage: "Block trace file (- for stdin)",
+	}
+	ProveWait = cli.BoolFlag{
+		Name:  "wait",
+		Usage: "Wait for the proof and print it instead of the job status",
+	}
+	ProvePollInterval = cli.DurationFlag{
+		Name:  "poll-interval",
+		Usage: "Interval to poll the job status while waiting",
+		Value: 10 * time.Second,
+	}
+	ResultOut = cli.StringFlag{
+		Name:  "out",
+		Usage: "File to write the proof to (- for stdout)",
+		Value: "-",
+	}
+	SpecRefresh = cli.BoolFlag{
+		Name:  "refresh",
+		Usage: "Fetch the spec from the prover instead of the cache. This starts the prover instance",
+	}
+)
+
+// clientCommands call a running proxy.
+var clientCommands = []cli.Command{
+	{
+		Name:   "prove",
+		Usage:  "Submit a block trace to the proxy",
+		Flags:  []cli.Flag{ProxyUrl, ProveTrace, ProveWait, ProvePollInterval},
+		Action: exitOnError(proveTrace),
+	},
+	{
+		Name:      "status",
+		Usage:     "Print the status of a proof job",
+		ArgsUsage: "<id>",
+		Flags:     []cli.Flag{ProxyUrl},
+		Action:    exitOnError(printStatus),
+	},
+	{
+		Name:      "result",
+		Usage:     "Fetch the proof of a completed job",
+		ArgsUsage: "<id>",
+		Flags:     []cli.Flag{ProxyUrl, ResultOut},
+		Action:    exitOnError(fetchResult),
+	},
+	{
+		Name:   "spec",
+		Usage:  "Print the spec of the prover",
+		Flags:  []cli.Flag{ProxyUrl, SpecRefresh},
+		Action: exitOnError(printSpec),
+	},
+}
+
+func proveTrace(ctx *cli.Context) error {
+	traceBytes, err := readInput(ctx.String(ProveTrace.Name))
+	if err != nil {
+		return err
+	}
+	client := proof.NewProxyClient(ctx.String(ProxyUrl.Name))
+	status, err := client.Submit(string(traceBytes))
+	if err != nil {
+		return fmt.Errorf("failed to submit trace: %w", err)
+	}
+	if !ctx.Bool(ProveWait.Name) {
+		return printJson(os.Stdout, status)
+	}
+	for status.State != proof.JobDone && status.State != proof.JobFailed {
+		log.Println("waiting proof generation.", "id:", status.Id, "state:", status.State)
+		time.Sleep(ctx.Duration(ProvePollInterval.Name))
+		if status, err = client.Status(status.Id); err != nil {
+			return fmt.Errorf("failed to read status: %w", err)
+		}
+		if status.State == proof.JobUnknown {
+			return fmt.Errorf("job %s is not found. the prover may have failed to start. submit the trace again", status.Id)
+		}
+	}
+	result, err := client.Result(status.Id)
+	if err != nil {
+		return fmt.Errorf("proof %s failed: %w", status.Id, err)
+	}
+	return printJson(os.Stdout, result)
+}
+
+func printStatus(ctx *cli.Context) error {
+	id, err := idArg(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := proof.NewProxyClient(ctx.String(ProxyUrl.Name)).Status(id)
+	if err != nil {
+		return fmt.Errorf("failed to read status: %w", err)
+	}
+	return printJson(os.Stdout, status)
+}
+
+func fetchResult(ctx *cli.Context) error {
+	id, err := idArg(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := proof.NewProxyClient(ctx.String(ProxyUrl.Name)).Result(id)
+	if err != nil {
+		return fmt.Errorf("failed to fetch proof: %w", err)
+	}
+	out := ctx.String(ResultOut.Name)
+	if out == "-" {
+		return printJson(os.Stdout, result)
+	}
+	file, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	defer file.Close()
+	return printJson(file, result)
+}
+
+func printSpec(ctx *cli.Context) error {
+	spec, err := proof.NewProxyClient(ctx.String(ProxyUrl.Name)).Spec(ctx.Bool(SpecRefresh.Name))
+	if err != nil {
+		return fmt.Errorf("failed to read spec: %w", err)
+	}
+	return printJson(os.Stdout, spec)
+}
+
+// exitOnError prints the error of a command and exits without the stack trace.
+func exitOnError(action func(ctx *cli.Context) error) func(ctx *cli.Context) error {
+	return func(ctx *cli.Context) error {
+		if err := action(ctx); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+}
+
+func idArg(ctx *cli.Context) (string, error) {
+	if ctx.NArg() != 1 {
+		return "", errors.New("id is required")
+	}
+	return ctx.Args().First(), nil
+}
+
+func readInput(name string) ([]byte, error) {
+	switch name {
+	case "":
+		return nil, errors.New("trace file is required")
+	case "-":
+		return io.ReadAll(os.Stdin)
+	default:
+		return os.ReadFile(name)
+	}
+}
+
+func printJson(writer io.Writer, value any) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/urfave/cli"
 )
 
@@ -17,26 +19,173 @@ var (
 		Value:  6000,
 		EnvVar: "JSONRPC_PORT",
 	}
+	JsonRpcMaxRequestSize = cli.Int64Flag{
+		Name: "jsonrpc.max-request-size",
+		Usage: "Maximum size of a Json Rpc request in bytes (0 for no limit). Behavior change: requests were not limited " +
+			"before this flag, and larger requests are now rejected with 413 Request Entity Too Large",
+		Value:  1 << 30,
+		EnvVar: "JSONRPC_MAX_REQUEST_SIZE",
+	}
+	JsonRpcSpoolDir = cli.StringFlag{
+		Name:   "jsonrpc.spool-dir",
+		Usage:  "A directory to store large traces until they are proven (default: the system temporary directory)",
+		EnvVar: "JSONRPC_SPOOL_DIR",
+	}
+	JsonRpcWsAllowedOrigins = cli.StringSliceFlag{
+		Name:   "jsonrpc.ws-allowed-origins",
+		Usage:  "Origins of web pages allowed to open a websocket, like 'https://app.example.com'. The origin of the proxy is always allowed",
+		EnvVar: "JSONRPC_WS_ALLOWED_ORIGINS",
+	}
+	JsonRpcClientRequestsPerMinute = cli.IntFlag{
+		Name:   "jsonrpc.client-requests-per-minute",
+		Usage:  "Proving requests per minute allowed to each client, identified by a configured X-Api-Key or IP address (0 for no limit)",
+		EnvVar: "JSONRPC_CLIENT_REQUESTS_PER_MINUTE",
+	}
+	JsonRpcClientMaxConcurrentJobs = cli.IntFlag{
+		Name:   "jsonrpc.client-max-concurrent-jobs",
+		Usage:  "Unfinished proof jobs allowed to each client, identified by a configured X-Api-Key or IP address (0 for no limit)",
+		EnvVar: "JSONRPC_CLIENT_MAX_CONCURRENT_JOBS",
+	}
+	JsonRpcAdminToken = cli.StringFlag{
+		Name:   "jsonrpc.admin-token",
+		Usage:  "Bearer token of the admin methods like admin_clientUsage. The admin methods are disabled if it is empty",
+		EnvVar: "JSONRPC_ADMIN_TOKEN",
+	}
+	JsonRpcApiKeys = cli.StringSliceFlag{
+		Name:   "jsonrpc.api-keys",
+		Usage:  "X-Api-Key values identifying clients. Requests with other keys are identified by IP address",
+		EnvVar: "JSONRPC_API_KEYS",
+	}
 	ProofBaseDir = cli.StringFlag{
 		Name:   "proof.base-dir",
 		Usage:  "A directory to temporarily store the generated proof",
 		Value:  "./proof",
 		EnvVar: "PROOF_BASE_DIR",
 	}
+	ProofRetentionMaxAge = cli.DurationFlag{
+		Name:   "proof.retention.max-age",
+		Usage:  "Maximum age of a stored proof (0 for no limit)",
+		Value:  7 * 24 * time.Hour,
+		EnvVar: "PROOF_RETENTION_MAX_AGE",
+	}
+	ProofRetentionMaxCount = cli.IntFlag{
+		Name:   "proof.retention.max-count",
+		Usage:  "Maximum number of stored proofs. The oldest are deleted first (0 for no limit)",
+		EnvVar: "PROOF_RETENTION_MAX_COUNT",
+	}
+	ProofRetentionMaxBytes = cli.Int64Flag{
+		Name:   "proof.retention.max-bytes",
+		Usage:  "Maximum total size of stored proofs in bytes. The oldest are deleted first (0 for no limit)",
+		EnvVar: "PROOF_RETENTION_MAX_BYTES",
+	}
+	ProofRetentionErrorMaxAge = cli.DurationFlag{
+		Name:   "proof.retention.error-max-age",
+		Usage:  "Maximum age of a stored proof error (0 for no limit)",
+		Value:  10 * time.Minute,
+		EnvVar: "PROOF_RETENTION_ERROR_MAX_AGE",
+	}
+	ProofRetentionErrorMaxCount = cli.IntFlag{
+		Name:   "proof.retention.error-max-count",
+		Usage:  "Maximum number of stored proof errors. The oldest are deleted first (0 for no limit)",
+		EnvVar: "PROOF_RETENTION_ERROR_MAX_COUNT",
+	}
+	ProofRetentionErrorMaxBytes = cli.Int64Flag{
+		Name:   "proof.retention.error-max-bytes",
+		Usage:  "Maximum total size of stored proof errors in bytes. The oldest are deleted first (0 for no limit)",
+		EnvVar: "PROOF_RETENTION_ERROR_MAX_BYTES",
+	}
+	ProofRetentionInterval = cli.DurationFlag{
+		Name:   "proof.retention.interval",
+		Usage:  "Interval between retention sweeps",
+		Value:  10 * time.Minute,
+		EnvVar: "PROOF_RETENTION_INTERVAL",
+	}
+	ProofRetentionDryRun = cli.BoolFlag{
+		Name:   "proof.retention.dry-run",
+		Usage:  "Only log the proofs the retention would delete",
+		EnvVar: "PROOF_RETENTION_DRY_RUN",
+	}
+	ProverMaxConcurrentProofs = cli.IntFlag{
+		Name: "prover.max-concurrent-proofs",
+		Usage: "Number of proofs sent to the prover at the same time. Others wait in the queue, ordered by priority and deadline " +
+			"(0 for one per prover instance, which is the maximum size of the group in autoscaling mode; -1 for unlimited, which disables the ordering)",
+		EnvVar: "PROVER_MAX_CONCURRENT_PROOFS",
+	}
+	ProverBreakerThreshold = cli.IntFlag{
+		Name:   "prover.breaker-threshold",
+		Usage:  "Consecutive prover failures that open the circuit breaker and reject proofs (0 to disable)",
+		Value:  5,
+		EnvVar: "PROVER_BREAKER_THRESHOLD",
+	}
+	ProverBreakerCooldown = cli.DurationFlag{
+		Name:   "prover.breaker-cooldown",
+		Usage:  "Time the open circuit breaker rejects proofs before probing the prover with a single proof",
+		Value:  10 * time.Minute,
+		EnvVar: "PROVER_BREAKER_COOLDOWN",
+	}
+	ProverPrewarm = cli.StringSliceFlag{
+		Name:   "prover.prewarm",
+		Usage:  "Window to keep the prover instance running as '<cron spec>;<duration>' (e.g. '0 9 * * 1-5;2h')",
+		EnvVar: "PROVER_PREWARM",
+	}
+	WebhookUrls = cli.StringSliceFlag{
+		Name:   "webhook.urls",
+		Usage:  "URLs notified of every completed proof",
+		EnvVar: "WEBHOOK_URLS",
+	}
+	WebhookSecret = cli.StringFlag{
+		Name: "webhook.secret",
+		Usage: "Secret to sign the timestamp and the payload of webhooks with HMAC-SHA256 " +
+			"(required if webhook.urls or webhook.callback-allowed-hosts is set)",
+		EnvVar: "WEBHOOK_SECRET",
+	}
+	WebhookCallbackAllowedHosts = cli.StringSliceFlag{
+		Name:   "webhook.callback-allowed-hosts",
+		Usage:  "Hosts allowed in the callbackUrl of prove_submit. Callbacks are rejected if it is empty",
+		EnvVar: "WEBHOOK_CALLBACK_ALLOWED_HOSTS",
+	}
 	AwsRegion = cli.StringFlag{
 		Name:   "aws.region",
 		Value:  "ap-northeast-2",
 		EnvVar: "AWS_REGION",
 	}
+	AwsProverMode = cli.StringFlag{
+		Name:   "aws.prover-mode",
+		Usage:  "How the prover instance is provisioned (instance, spot, ephemeral, asg, failover)",
+		Value:  "instance",
+		EnvVar: "AWS_PROVER_MODE",
+	}
 	AwsProverInstanceId = cli.StringFlag{
-		Name:     "aws.prover-instance-id",
-		Usage:    "EC instance ID to generate the proof",
-		EnvVar:   "AWS_PROVER_INSTANCE_ID",
-		Required: true,
+		Name:   "aws.prover-instance-id",
+		Usage:  "EC instance ID to generate the proof (required in instance mode)",
+		EnvVar: "AWS_PROVER_INSTANCE_ID",
+	}
+	AwsFailoverTargets = cli.StringSliceFlag{
+		Name: "aws.failover-targets",
+		Usage: "Regions in order of preference with their prover instances as '<region>=<instance id>[+<instance id>...]' " +
+			"(required in failover mode). The instances of a region are tried in order, and only one instance runs at a time",
+		EnvVar: "AWS_FAILOVER_TARGETS",
+	}
+	AwsFailoverReadyTimeout = cli.DurationFlag{
+		Name:   "aws.failover-ready-timeout",
+		Usage:  "Time to wait for the prover server before failing over to the next region",
+		Value:  15 * time.Minute,
+		EnvVar: "AWS_FAILOVER_READY_TIMEOUT",
+	}
+	AwsStatusCheckTimeout = cli.DurationFlag{
+		Name: "aws.status-check-timeout",
+		Usage: "Time to wait for the EC2 system and instance status checks of a started prover instance (0 to skip them). " +
+			"The checks need the ec2:DescribeInstanceStatus permission, and ec2:RebootInstances with aws.reboot-impaired",
+		EnvVar: "AWS_STATUS_CHECK_TIMEOUT",
+	}
+	AwsRebootImpaired = cli.IntFlag{
+		Name:   "aws.reboot-impaired",
+		Usage:  "Number of times a prover instance failing its status checks is rebooted before the start fails",
+		EnvVar: "AWS_REBOOT_IMPAIRED",
 	}
 	AwsProverAddressType = cli.StringFlag{
 		Name:   "aws.prover-address-type",
-		Usage:  "EC instance address type (private, public)",
+		Usage:  "EC instance address type (private, public, private-dns, public-dns, elastic-ip)",
 		Value:  "private",
 		EnvVar: "AWS_PROVER_ADDRESS_TYPE",
 	}
@@ -52,17 +201,107 @@ var (
 		Value:  3030,
 		EnvVar: "AWS_PROVER_JSONRPC_PORT",
 	}
+	AwsAutoScalingGroup = cli.StringFlag{
+		Name:   "aws.auto-scaling-group",
+		Usage:  "Auto Scaling Group name of the prover instances (asg mode)",
+		EnvVar: "AWS_AUTO_SCALING_GROUP",
+	}
+	AwsHourlyPrices = cli.StringSliceFlag{
+		Name:   "aws.hourly-prices",
+		Usage:  "Hourly price per instance type for cost accounting (e.g. g5.2xlarge=1.212)",
+		EnvVar: "AWS_HOURLY_PRICES",
+	}
+	AwsLaunchTemplate = cli.StringFlag{
+		Name:   "aws.launch-template",
+		Usage:  "Launch template id or name to launch the prover instance (spot, ephemeral mode)",
+		EnvVar: "AWS_LAUNCH_TEMPLATE",
+	}
+	AwsLaunchTemplateVersion = cli.StringFlag{
+		Name:   "aws.launch-template-version",
+		Usage:  "Launch template version",
+		Value:  "$Default",
+		EnvVar: "AWS_LAUNCH_TEMPLATE_VERSION",
+	}
+	AwsImageId = cli.StringFlag{
+		Name:   "aws.image-id",
+		Usage:  "AMI ID to launch the prover instance, overrides the launch template",
+		EnvVar: "AWS_IMAGE_ID",
+	}
+	AwsInstanceType = cli.StringFlag{
+		Name:   "aws.instance-type",
+		Usage:  "Instance type to launch the prover instance, overrides the launch template",
+		EnvVar: "AWS_INSTANCE_TYPE",
+	}
+	AwsSubnetId = cli.StringFlag{
+		Name:   "aws.subnet-id",
+		Usage:  "Subnet ID to launch the prover instance, overrides the launch template",
+		EnvVar: "AWS_SUBNET_ID",
+	}
+	AwsSecurityGroupIds = cli.StringSliceFlag{
+		Name:   "aws.security-group-ids",
+		Usage:  "Security group IDs to launch the prover instance, overrides the launch template",
+		EnvVar: "AWS_SECURITY_GROUP_IDS",
+	}
+	AwsLaunchOwner = cli.StringFlag{
+		Name:   "aws.launch-owner",
+		Usage:  "Owner tag of launched instances, unique to this proxy. Tagged instances left by a previous run are terminated on startup. Required in spot and ephemeral mode",
+		EnvVar: "AWS_LAUNCH_OWNER",
+	}
+	AwsSpotMaxFailures = cli.IntFlag{
+		Name:   "aws.spot-max-failures",
+		Usage:  "Consecutive spot launch failures and interruptions before falling back to on-demand",
+		Value:  3,
+		EnvVar: "AWS_SPOT_MAX_FAILURES",
+	}
 )
 
 func AllFlags() []cli.Flag {
 	return []cli.Flag{
 		JsonRpcAddr,
 		JsonRpcPort,
+		JsonRpcMaxRequestSize,
+		JsonRpcSpoolDir,
+		JsonRpcWsAllowedOrigins,
+		JsonRpcClientRequestsPerMinute,
+		JsonRpcClientMaxConcurrentJobs,
+		JsonRpcApiKeys,
+		JsonRpcAdminToken,
 		ProofBaseDir,
+		ChainsConfig,
+		ProofRetentionMaxAge,
+		ProofRetentionMaxCount,
+		ProofRetentionMaxBytes,
+		ProofRetentionErrorMaxAge,
+		ProofRetentionErrorMaxCount,
+		ProofRetentionErrorMaxBytes,
+		ProofRetentionInterval,
+		ProofRetentionDryRun,
+		ProverMaxConcurrentProofs,
+		ProverBreakerThreshold,
+		ProverBreakerCooldown,
+		ProverPrewarm,
+		WebhookUrls,
+		WebhookSecret,
+		WebhookCallbackAllowedHosts,
 		AwsRegion,
+		AwsProverMode,
 		AwsProverInstanceId,
+		AwsFailoverTargets,
+		AwsFailoverReadyTimeout,
+		AwsStatusCheckTimeout,
+		AwsRebootImpaired,
 		AwsProverAddressType,
 		AwsProverUrlSchema,
 		AwsProverJsonRpcPort,
+		AwsAutoScalingGroup,
+		AwsHourlyPrices,
+		AwsLaunchTemplate,
+		AwsLaunchTemplateVersion,
+		AwsImageId,
+		AwsInstanceType,
+		AwsSubnetId,
+		AwsSecurityGroupIds,
+		AwsLaunchOwner,
+		AwsSpotMaxFailures,
 	}
 }
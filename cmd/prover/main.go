@@ -7,12 +7,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/kroma-network/kroma-prover-proxy/internal/ec2"
 	"github.com/kroma-network/kroma-prover-proxy/internal/proof"
+	"github.com/kroma-network/kroma-prover-proxy/internal/webhook"
 	"github.com/urfave/cli"
 )
 
@@ -22,6 +25,7 @@ func main() {
 	app.Version = "0.0.1"
 	app.Flags = AllFlags()
 	app.Action = proverProxy
+	app.Commands = append(clientCommands, proofsCommand)
 	if err := app.Run(os.Args); err != nil {
 		log.Panicln(fmt.Errorf("failed to start kroma proof proxy: %w", err))
 	}
@@ -56,16 +60,170 @@ func proverProxy(ctx *cli.Context) {
 }
 
 func newServer(ctx *cli.Context) *proof.Server {
-	return proof.NewServer(
-		proof.NewService(
-			proof.NewDiskRepository(ctx.String(ProofBaseDir.Name)),
-			ec2.MustNewController(
-				ctx.String(AwsRegion.Name),
-				ctx.String(AwsProverInstanceId.Name),
-				ctx.String(AwsProverAddressType.Name),
-				ctx.String(AwsProverUrlSchema.Name),
-				ctx.Int(AwsProverJsonRpcPort.Name),
+	options := []proof.ServerOption{
+		proof.WithClientLimits(proof.ClientLimits{
+			RequestsPerMinute: ctx.Int(JsonRpcClientRequestsPerMinute.Name),
+			MaxConcurrentJobs: ctx.Int(JsonRpcClientMaxConcurrentJobs.Name),
+			ApiKeys:           ctx.StringSlice(JsonRpcApiKeys.Name),
+		}),
+		proof.WithAdminToken(ctx.String(JsonRpcAdminToken.Name)),
+		proof.WithMaxRequestSize(ctx.Int64(JsonRpcMaxRequestSize.Name)),
+		proof.WithSpoolDir(ctx.String(JsonRpcSpoolDir.Name)),
+		proof.WithWebSocketOrigins(ctx.StringSlice(JsonRpcWsAllowedOrigins.Name)),
+	}
+	if config := ctx.String(ChainsConfig.Name); len(config) != 0 {
+		backends := make(map[uint64]*proof.Service)
+		for chainId, flags := range loadChainFlags(ctx, config) {
+			backends[chainId] = newService(flags, proof.WithChainId(chainId))
+		}
+		return proof.NewMultiChainServer(backends, options...)
+	}
+	return proof.NewServer(newService(ctx), options...)
+}
+
+func newService(ctx flagValues, options ...proof.ServiceOption) *proof.Service {
+	webhookUrls, callbackHosts := ctx.StringSlice(WebhookUrls.Name), ctx.StringSlice(WebhookCallbackAllowedHosts.Name)
+	if (len(webhookUrls) != 0 || len(callbackHosts) != 0) && len(ctx.String(WebhookSecret.Name)) == 0 {
+		log.Panicf("%s is required if %s or %s is set\n", WebhookSecret.Name, WebhookUrls.Name, WebhookCallbackAllowedHosts.Name)
+	}
+	disk := proof.NewDiskRepository(ctx.String(ProofBaseDir.Name))
+	disk.StartRetention(proof.RetentionConfig{
+		Success: proof.RetentionPolicy{
+			MaxAge:   ctx.Duration(ProofRetentionMaxAge.Name),
+			MaxCount: ctx.Int(ProofRetentionMaxCount.Name),
+			MaxBytes: ctx.Int64(ProofRetentionMaxBytes.Name),
+		},
+		Error: proof.RetentionPolicy{
+			MaxAge:   ctx.Duration(ProofRetentionErrorMaxAge.Name),
+			MaxCount: ctx.Int(ProofRetentionErrorMaxCount.Name),
+			MaxBytes: ctx.Int64(ProofRetentionErrorMaxBytes.Name),
+		},
+		Interval: ctx.Duration(ProofRetentionInterval.Name),
+		DryRun:   ctx.Bool(ProofRetentionDryRun.Name),
+	})
+	return proof.NewService(
+		disk,
+		newController(ctx),
+		append([]proof.ServiceOption{
+			proof.WithHourlyPrices(parseHourlyPrices(ctx.StringSlice(AwsHourlyPrices.Name))),
+			proof.WithMaxConcurrentProofs(ctx.Int(ProverMaxConcurrentProofs.Name)),
+			proof.WithCircuitBreaker(ctx.Int(ProverBreakerThreshold.Name), ctx.Duration(ProverBreakerCooldown.Name)),
+			proof.WithPrewarmWindows(parsePrewarmWindows(ctx.StringSlice(ProverPrewarm.Name))),
+			proof.WithWebhooks(
+				webhook.NewOutbox(filepath.Join(ctx.String(ProofBaseDir.Name), ".outbox"), ctx.String(WebhookSecret.Name)),
+				webhookUrls,
 			),
-		),
+			proof.WithCallbackAllowedHosts(callbackHosts),
+		}, options...)...,
 	)
 }
+
+func parsePrewarmWindows(values []string) []proof.PrewarmWindow {
+	var windows []proof.PrewarmWindow
+	for _, value := range values {
+		window, err := proof.ParsePrewarmWindow(value)
+		if err != nil {
+			log.Panicln(err)
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+func parseHourlyPrices(values []string) map[string]float64 {
+	prices := make(map[string]float64)
+	for _, value := range values {
+		instanceType, price, ok := strings.Cut(value, "=")
+		if !ok {
+			log.Panicf("invalid hourly price %s\n", value)
+		}
+		parsed, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			log.Panicln(fmt.Errorf("invalid hourly price %s: %w", value, err))
+		}
+		prices[strings.TrimSpace(instanceType)] = parsed
+	}
+	return prices
+}
+
+func newController(ctx flagValues) proof.InstanceController {
+	statusChecks := ec2.StatusCheckConfig{
+		Timeout:    ctx.Duration(AwsStatusCheckTimeout.Name),
+		MaxReboots: ctx.Int(AwsRebootImpaired.Name),
+	}
+	switch mode := ctx.String(AwsProverMode.Name); mode {
+	case "instance":
+		if len(ctx.String(AwsProverInstanceId.Name)) == 0 {
+			log.Panicf("%s is required in instance mode\n", AwsProverInstanceId.Name)
+		}
+		return ec2.MustNewController(
+			ctx.String(AwsRegion.Name),
+			ctx.String(AwsProverInstanceId.Name),
+			ctx.String(AwsProverAddressType.Name),
+			ctx.String(AwsProverUrlSchema.Name),
+			ctx.Int(AwsProverJsonRpcPort.Name),
+			statusChecks,
+		)
+	case "spot", "ephemeral":
+		if mode == "ephemeral" && len(ctx.String(AwsLaunchTemplate.Name)) == 0 {
+			log.Panicf("%s is required in ephemeral mode\n", AwsLaunchTemplate.Name)
+		}
+		// Instances of the owner are terminated on startup. A shared default would terminate the instances of
+		// other proxies in the account.
+		if len(ctx.String(AwsLaunchOwner.Name)) == 0 {
+			log.Panicf("%s is required in %s mode\n", AwsLaunchOwner.Name, mode)
+		}
+		return ec2.MustNewLaunchController(
+			ctx.String(AwsRegion.Name),
+			ec2.LaunchConfig{
+				LaunchTemplate:        ctx.String(AwsLaunchTemplate.Name),
+				LaunchTemplateVersion: ctx.String(AwsLaunchTemplateVersion.Name),
+				ImageId:               ctx.String(AwsImageId.Name),
+				InstanceType:          ctx.String(AwsInstanceType.Name),
+				SubnetId:              ctx.String(AwsSubnetId.Name),
+				SecurityGroupIds:      ctx.StringSlice(AwsSecurityGroupIds.Name),
+				Owner:                 ctx.String(AwsLaunchOwner.Name),
+				Spot:                  mode == "spot",
+				MaxSpotFailures:       ctx.Int(AwsSpotMaxFailures.Name),
+				StatusChecks:          statusChecks,
+			},
+			ctx.String(AwsProverAddressType.Name),
+			ctx.String(AwsProverUrlSchema.Name),
+			ctx.Int(AwsProverJsonRpcPort.Name),
+		)
+	case "asg":
+		if len(ctx.String(AwsAutoScalingGroup.Name)) == 0 {
+			log.Panicf("%s is required in asg mode\n", AwsAutoScalingGroup.Name)
+		}
+		return ec2.MustNewAutoScalingController(
+			ctx.String(AwsRegion.Name),
+			ctx.String(AwsAutoScalingGroup.Name),
+			ctx.String(AwsProverAddressType.Name),
+			ctx.String(AwsProverUrlSchema.Name),
+			ctx.Int(AwsProverJsonRpcPort.Name),
+		)
+	case "failover":
+		var targets []ec2.FailoverTarget
+		for _, value := range ctx.StringSlice(AwsFailoverTargets.Name) {
+			target, err := ec2.ParseFailoverTarget(value)
+			if err != nil {
+				log.Panicln(err)
+			}
+			targets = append(targets, target)
+		}
+		if len(targets) == 0 {
+			log.Panicf("%s is required in failover mode\n", AwsFailoverTargets.Name)
+		}
+		return ec2.MustNewFailoverController(
+			targets,
+			ctx.String(AwsProverAddressType.Name),
+			ctx.String(AwsProverUrlSchema.Name),
+			ctx.Int(AwsProverJsonRpcPort.Name),
+			ctx.Duration(AwsFailoverReadyTimeout.Name),
+			statusChecks,
+		)
+	default:
+		log.Panicf("invalid prover mode %s\n", mode)
+		return nil
+	}
+}
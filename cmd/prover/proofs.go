@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	"github.com/kroma-network/kroma-prover-proxy/internal/proof"
+	"github.com/urfave/cli"
+)
+
+var (
+	ProofsChain = cli.Uint64Flag{
+		Name:  "chain",
+		Usage: "Chain id of the proofs. It is required if chains.config is set",
+	}
+	ExportOut = cli.StringFlag{
+		Name:  "out",
+		Usage: "File to write the archive to (- for stdout)",
+		Value: "proofs.tar.gz",
+	}
+	ExportIds = cli.StringSliceFlag{
+		Name:  "id",
+		Usage: "Id of a proof to export. All proofs are exported if neither ids nor a block range is given",
+	}
+	ExportFromBlock = cli.StringFlag{
+		Name:  "from-block",
+		Usage: "First block number of the proofs to export",
+	}
+	ExportToBlock = cli.StringFlag{
+		Name:  "to-block",
+		Usage: "Last block number of the proofs to export",
+	}
+	ImportIn = cli.StringFlag{
+		Name:  "in",
+		Usage: "Archive file to import (- for stdin)",
+		Value: "proofs.tar.gz",
+	}
+)
+
+// proofsCommand manages the stored proofs. The proxy must be stopped, because the repository is opened exclusively.
+var proofsCommand = cli.Command{
+	Name:  "proofs",
+	Usage: "Manage the stored proofs",
+	Subcommands: []cli.Command{
+		{
+			Name:   "export",
+			Usage:  "Export proofs to a compressed tar archive with a manifest",
+			Flags:  []cli.Flag{ProofsChain, ExportOut, ExportIds, ExportFromBlock, ExportToBlock},
+			Action: exitOnError(exportProofs),
+		},
+		{
+			Name:   "import",
+			Usage:  "Import proofs from an archive written by export. Proofs already stored are skipped",
+			Flags:  []cli.Flag{ProofsChain, ImportIn},
+			Action: exitOnError(importProofs),
+		},
+	},
+}
+
+// proofRepository opens the repository of the chain given by --chain if chains.config is set, or the
+// repository at proof.base-dir otherwise.
+func proofRepository(ctx *cli.Context) (*proof.DiskRepository, error) {
+	global := ctx
+	for global.Parent() != nil {
+		global = global.Parent()
+	}
+	config := global.String(ChainsConfig.Name)
+	chainId := ctx.Uint64(ProofsChain.Name)
+	if len(config) == 0 {
+		if chainId != 0 {
+			return nil, fmt.Errorf("--%s requires %s", ProofsChain.Name, ChainsConfig.Name)
+		}
+		return proof.NewDiskRepository(global.String(ProofBaseDir.Name)), nil
+	}
+	if chainId == 0 {
+		return nil, fmt.Errorf("--%s is required, because the chains are configured in %s", ProofsChain.Name, config)
+	}
+	flags, ok := loadChainFlags(global, config)[chainId]
+	if !ok {
+		return nil, fmt.Errorf("chain %d is not configured in %s", chainId, config)
+	}
+	return proof.NewDiskRepository(flags.String(ProofBaseDir.Name)), nil
+}
+
+func exportProofs(ctx *cli.Context) error {
+	repository, err := proofRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+	ids, err := exportIds(ctx, repository)
+	if err != nil {
+		return err
+	}
+	var writer io.Writer = os.Stdout
+	if out := ctx.String(ExportOut.Name); out != "-" {
+		file, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", out, err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	count, err := proof.ExportArchive(writer, repository, ids)
+	if err != nil {
+		return fmt.Errorf("failed to export proofs: %w", err)
+	}
+	log.Printf("exported %d proofs\n", count)
+	return nil
+}
+
+func exportIds(ctx *cli.Context, repository proof.Repository) ([]string, error) {
+	if ids := ctx.StringSlice(ExportIds.Name); len(ids) != 0 {
+		return ids, nil
+	}
+	var metas []*proof.ProofMeta
+	if ctx.IsSet(ExportFromBlock.Name) || ctx.IsSet(ExportToBlock.Name) {
+		from, to := uint64(0), uint64(math.MaxUint64)
+		var err error
+		if ctx.IsSet(ExportFromBlock.Name) {
+			if from, err = proof.ParseBlockNumber(ctx.String(ExportFromBlock.Name)); err != nil {
+				return nil, err
+			}
+		}
+		if ctx.IsSet(ExportToBlock.Name) {
+			if to, err = proof.ParseBlockNumber(ctx.String(ExportToBlock.Name)); err != nil {
+				return nil, err
+			}
+		}
+		metas = repository.ListByBlockRange(from, to, 0)
+	} else {
+		metas = repository.List(0)
+	}
+	ids := make([]string, 0, len(metas))
+	for _, meta := range metas {
+		ids = append(ids, meta.Id)
+	}
+	return ids, nil
+}
+
+func importProofs(ctx *cli.Context) error {
+	repository, err := proofRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+	var reader io.Reader = os.Stdin
+	if in := ctx.String(ImportIn.Name); in != "-" {
+		file, err := os.Open(in)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", in, err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	result, err := proof.ImportArchive(reader, repository)
+	if err != nil {
+		return fmt.Errorf("failed to import proofs: %w", err)
+	}
+	log.Printf("imported %d proofs. skipped %d duplicates and %d invalid proofs\n", result.Imported, result.Duplicates, result.Invalid)
+	if result.Invalid != 0 {
+		return fmt.Errorf("%d proofs in the archive are invalid", result.Invalid)
+	}
+	return nil
+}
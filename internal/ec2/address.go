@@ -0,0 +1,66 @@
+package ec2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	AddressTypePrivate    = "private"
+	AddressTypePublic     = "public"
+	AddressTypePrivateDns = "private-dns"
+	AddressTypePublicDns  = "public-dns"
+	AddressTypeElasticIp  = "elastic-ip"
+)
+
+// endpoint describes how the prover json rpc url is built from an instance.
+type endpoint struct {
+	addressType string
+	urlSchema   string
+	port        int
+}
+
+func newEndpoint(addressType string, urlSchema string, port int) (endpoint, error) {
+	addressType = strings.ToLower(strings.TrimSpace(addressType))
+	switch addressType {
+	case AddressTypePrivate, AddressTypePublic, AddressTypePrivateDns, AddressTypePublicDns, AddressTypeElasticIp:
+		return endpoint{addressType: addressType, urlSchema: urlSchema, port: port}, nil
+	default:
+		return endpoint{}, fmt.Errorf("invalid instanceAddressType %v", addressType)
+	}
+}
+
+// resolve returns the prover url of the instance. Public addresses and DNS names are
+// only assigned while the instance is running, so this has to be called after every start.
+func (e endpoint) resolve(client *ec2.EC2, instance *ec2.Instance) (string, error) {
+	var address string
+	switch e.addressType {
+	case AddressTypePrivate:
+		address = aws.StringValue(instance.PrivateIpAddress)
+	case AddressTypePublic:
+		address = aws.StringValue(instance.PublicIpAddress)
+	case AddressTypePrivateDns:
+		address = aws.StringValue(instance.PrivateDnsName)
+	case AddressTypePublicDns:
+		address = aws.StringValue(instance.PublicDnsName)
+	case AddressTypeElasticIp:
+		output, err := client.DescribeAddresses(&ec2.DescribeAddressesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: []*string{instance.InstanceId}}},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to describe elastic ip of %s: %w", aws.StringValue(instance.InstanceId), err)
+		}
+		if len(output.Addresses) != 0 {
+			address = aws.StringValue(output.Addresses[0].PublicIp)
+		}
+	}
+	if len(address) == 0 {
+		return "", errors.New("failed to retrieve instance address")
+	}
+	return e.urlSchema + "://" + address + ":" + strconv.Itoa(e.port), nil
+}
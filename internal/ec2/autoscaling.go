@@ -0,0 +1,356 @@
+package ec2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AutoScalingController drives the desired capacity of an Auto Scaling Group from the number of jobs.
+// Instances with a job in progress are protected from scale-in. No lock is held during the calls of the AWS APIs
+// except by the calls which must be ordered, so that Running and IpAddress do not wait for them.
+type AutoScalingController struct {
+	feed
+	pendingStart
+	autoScaling *autoscaling.AutoScaling
+	client      *ec2.EC2
+	region      string
+	groupName   string
+	endpoint    endpoint
+	desired     atomic.Int64
+	maxSize     int64
+	// scaleMu orders the changes of the desired capacity.
+	scaleMu sync.Mutex
+	// mu guards leases.
+	mu     sync.Mutex
+	leases map[string]int
+	// protectMu orders the changes of the scale-in protection. protected are the protected instances.
+	protectMu sync.Mutex
+	protected map[string]bool
+	tracked   map[string]Event
+	trackMu   sync.Mutex
+}
+
+func MustNewAutoScalingController(
+	region string,
+	groupName string,
+	instanceAddressType string,
+	urlSchema string,
+	port int,
+) *AutoScalingController {
+	endpoint, err := newEndpoint(instanceAddressType, urlSchema, port)
+	if err != nil {
+		log.Panicln(err)
+	}
+	sess, err := session.NewSession(&aws.Config{Region: &region})
+	if err != nil {
+		log.Panicln(fmt.Errorf("failed to create auto scaling controller: %w", err))
+	}
+	controller := &AutoScalingController{
+		autoScaling: autoscaling.New(sess),
+		client:      ec2.New(sess),
+		region:      region,
+		groupName:   groupName,
+		endpoint:    endpoint,
+		leases:      make(map[string]int),
+		protected:   make(map[string]bool),
+		tracked:     make(map[string]Event),
+	}
+	group, err := controller.findGroup()
+	if err != nil {
+		log.Panicln(fmt.Errorf("failed to read auto scaling group %s: %w", groupName, err))
+	}
+	controller.desired.Store(aws.Int64Value(group.DesiredCapacity))
+	controller.maxSize = aws.Int64Value(group.MaxSize)
+	go controller.watch(1 * time.Minute)
+	return controller
+}
+
+// watch refreshes the instances of the group periodically so that start and stop events
+// are emitted even when no job is running.
+func (c *AutoScalingController) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.trackMu.Lock()
+		tracking := len(c.tracked) != 0
+		c.trackMu.Unlock()
+		if tracking || c.Running() {
+			if _, err := c.healthyInstances(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func (c *AutoScalingController) Region() string { return c.region }
+
+// Capacity returns the maximum size of the group when the controller is created. Each instance proves a job at a time.
+func (c *AutoScalingController) Capacity() int { return int(c.maxSize) }
+
+func (c *AutoScalingController) Running() bool { return c.desired.Load() > 0 }
+
+// IpAddress returns the address of the least leased healthy instance.
+func (c *AutoScalingController) IpAddress() string {
+	_, address, err := c.pick(false)
+	if err != nil {
+		log.Println(fmt.Errorf("failed to find healthy instance in %s: %w", c.groupName, err))
+	}
+	return address
+}
+
+// SetDemand sets the desired capacity to the number of queued and in-progress jobs.
+func (c *AutoScalingController) SetDemand(jobs int) {
+	c.scaleMu.Lock()
+	defer c.scaleMu.Unlock()
+	if err := c.setDesired(int64(jobs)); err != nil {
+		log.Println(fmt.Errorf("failed to set desired capacity of %s: %w", c.groupName, err))
+	}
+}
+
+func (c *AutoScalingController) StartIfNotRunning() error {
+	c.scaleMu.Lock()
+	if c.desired.Load() == 0 {
+		if err := c.setDesired(1); err != nil {
+			c.scaleMu.Unlock()
+			return fmt.Errorf("failed to scale out %s: %w", c.groupName, err)
+		}
+	}
+	c.scaleMu.Unlock()
+	ctx, done := c.begin()
+	defer done()
+	ctx, cancel := context.WithTimeout(ctx, healthyTimeout)
+	defer cancel()
+	for {
+		instances, err := c.healthyInstances()
+		if err != nil {
+			return err
+		}
+		if len(instances) != 0 {
+			return nil
+		}
+		log.Printf("waiting for a healthy instance in %s", c.groupName)
+		if err := sleep(ctx, waiterDelay); err != nil {
+			return fmt.Errorf("no healthy instance in %s: %w", c.groupName, err)
+		}
+	}
+}
+
+// StopIfRunning scales the group in to zero. Instances still protected by a lease are kept until released.
+func (c *AutoScalingController) StopIfRunning() {
+	c.CancelStart()
+	c.scaleMu.Lock()
+	defer c.scaleMu.Unlock()
+	if c.desired.Load() > 0 {
+		if err := c.setDesired(0); err != nil {
+			log.Println(fmt.Errorf("failed to scale in %s: %w", c.groupName, err))
+		}
+	}
+}
+
+// Lease returns the address of a healthy instance and protects it from scale-in until release is called.
+func (c *AutoScalingController) Lease() (string, func(), error) {
+	instanceId, address, err := c.pick(true)
+	if err == nil {
+		err = c.syncProtection(instanceId)
+	}
+	if err != nil {
+		if len(instanceId) != 0 {
+			c.unlease(instanceId)
+		}
+		return "", nil, err
+	}
+	var once sync.Once
+	release := func() { once.Do(func() { c.unlease(instanceId) }) }
+	return address, release, nil
+}
+
+// unlease releases a lease of the instance, and removes its protection if it is the last one.
+func (c *AutoScalingController) unlease(instanceId string) {
+	c.mu.Lock()
+	c.leases[instanceId]--
+	if c.leases[instanceId] <= 0 {
+		delete(c.leases, instanceId)
+	}
+	c.mu.Unlock()
+	if err := c.syncProtection(instanceId); err != nil {
+		log.Println(err)
+	}
+}
+
+// syncProtection protects the instance from scale-in while it is leased. It reads the leases when it applies the
+// protection, so that concurrent leases and releases leave the protection of the last one.
+func (c *AutoScalingController) syncProtection(instanceId string) error {
+	c.protectMu.Lock()
+	defer c.protectMu.Unlock()
+	c.mu.Lock()
+	leased := c.leases[instanceId] > 0
+	c.mu.Unlock()
+	if c.protected[instanceId] == leased {
+		return nil
+	}
+	if err := c.protect(instanceId, leased); err != nil {
+		return err
+	}
+	if leased {
+		c.protected[instanceId] = true
+	} else {
+		delete(c.protected, instanceId)
+	}
+	return nil
+}
+
+// pick returns the healthy instance with the fewest leases, and leases it if lease is set. The instance is returned
+// with an error if its address cannot be resolved.
+func (c *AutoScalingController) pick(lease bool) (string, string, error) {
+	instances, err := c.healthyInstances()
+	if err != nil {
+		return "", "", err
+	}
+	if len(instances) == 0 {
+		return "", "", errors.New("no healthy instance")
+	}
+	c.mu.Lock()
+	var picked *ec2.Instance
+	for _, instance := range instances {
+		if picked == nil || c.leases[aws.StringValue(instance.InstanceId)] < c.leases[aws.StringValue(picked.InstanceId)] {
+			picked = instance
+		}
+	}
+	instanceId := aws.StringValue(picked.InstanceId)
+	if lease {
+		c.leases[instanceId]++
+	}
+	c.mu.Unlock()
+	address, err := c.endpoint.resolve(c.client, picked)
+	return instanceId, address, err
+}
+
+func (c *AutoScalingController) findGroup() (*autoscaling.Group, error) {
+	output, err := c.autoScaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{&c.groupName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return nil, errors.New("auto scaling group not found")
+	}
+	return output.AutoScalingGroups[0], nil
+}
+
+func (c *AutoScalingController) healthyInstances() ([]*ec2.Instance, error) {
+	group, err := c.findGroup()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auto scaling group %s: %w", c.groupName, err)
+	}
+	var ids []*string
+	healthy := make(map[string]bool)
+	for _, instance := range group.Instances {
+		ids = append(ids, instance.InstanceId)
+		healthy[aws.StringValue(instance.InstanceId)] = aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService &&
+			aws.StringValue(instance.HealthStatus) == "Healthy"
+	}
+	var running []*ec2.Instance
+	if len(ids) != 0 {
+		output, err := c.client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: ids})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read instances of %s: %w", c.groupName, err)
+		}
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				if aws.StringValue(instance.State.Name) == ec2.InstanceStateNameRunning {
+					running = append(running, instance)
+				}
+			}
+		}
+	}
+	c.track(running)
+	var instances []*ec2.Instance
+	for _, instance := range running {
+		if healthy[aws.StringValue(instance.InstanceId)] {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+// track emits start and stop events by comparing the running instances with the previously seen ones.
+func (c *AutoScalingController) track(running []*ec2.Instance) {
+	c.trackMu.Lock()
+	defer c.trackMu.Unlock()
+	seen := make(map[string]bool)
+	for _, instance := range running {
+		instanceId := aws.StringValue(instance.InstanceId)
+		seen[instanceId] = true
+		if _, ok := c.tracked[instanceId]; ok {
+			continue
+		}
+		address, _ := c.endpoint.resolve(c.client, instance)
+		event := Event{
+			Type:         EventStart,
+			InstanceId:   instanceId,
+			InstanceType: aws.StringValue(instance.InstanceType),
+			Region:       c.region,
+			Address:      address,
+		}
+		c.tracked[instanceId] = event
+		c.emit(event)
+	}
+	for instanceId, event := range c.tracked {
+		if !seen[instanceId] {
+			delete(c.tracked, instanceId)
+			event.Type = EventStop
+			event.Time = time.Time{}
+			c.emit(event)
+		}
+	}
+}
+
+// setDesired sets the desired capacity clamped to the group size limits. c.scaleMu must be held.
+func (c *AutoScalingController) setDesired(desired int64) error {
+	group, err := c.findGroup()
+	if err != nil {
+		return err
+	}
+	if max := aws.Int64Value(group.MaxSize); desired > max {
+		desired = max
+	}
+	if min := aws.Int64Value(group.MinSize); desired < min {
+		desired = min
+	}
+	if desired == aws.Int64Value(group.DesiredCapacity) {
+		c.desired.Store(desired)
+		return nil
+	}
+	log.Printf("set desired capacity of %s to %d", c.groupName, desired)
+	_, err = c.autoScaling.SetDesiredCapacity(&autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: &c.groupName,
+		DesiredCapacity:      aws.Int64(desired),
+	})
+	if err == nil {
+		c.desired.Store(desired)
+	}
+	return err
+}
+
+func (c *AutoScalingController) protect(instanceId string, protected bool) error {
+	_, err := c.autoScaling.SetInstanceProtection(&autoscaling.SetInstanceProtectionInput{
+		AutoScalingGroupName: &c.groupName,
+		InstanceIds:          []*string{&instanceId},
+		ProtectedFromScaleIn: aws.Bool(protected),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set scale-in protection of %s to %v: %w", instanceId, protected, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package ec2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestAutoScalingStartTimeout(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	endpoint, _ := newEndpoint(AddressTypePrivate, "http", 3030)
+	c := &AutoScalingController{
+		autoScaling: autoscaling.New(session.Must(session.NewSession(&client.Config))),
+		client:      client,
+		region:      "us-east-1",
+		groupName:   "provers",
+		endpoint:    endpoint,
+		leases:      make(map[string]int),
+		tracked:     make(map[string]Event),
+	}
+
+	started := time.Now()
+	if err := c.StartIfNotRunning(); err == nil {
+		t.Errorf("start must fail if the group has no healthy instance")
+	}
+	if time.Since(started) > 10*time.Second {
+		t.Errorf("start must time out. took %v", time.Since(started))
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.desired["provers"] != 1 {
+		t.Errorf("start must scale out the group. desired capacity %d", fake.desired["provers"])
+	}
+}
@@ -1,11 +1,10 @@
 package ec2
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -15,12 +14,18 @@ import (
 )
 
 type Controller struct {
-	client     *ec2.EC2
-	region     string
-	instanceId string
-	ipAddress  string
-	running    bool
-	mu         sync.Mutex
+	feed
+	pendingStart
+	client       *ec2.EC2
+	region       string
+	instanceId   string
+	instanceType string
+	endpoint     endpoint
+	statusChecks StatusCheckConfig
+	ipAddress    string
+	running      bool
+	mu           sync.Mutex
+	addressMu    sync.RWMutex
 }
 
 func MustNewController(
@@ -29,10 +34,11 @@ func MustNewController(
 	instanceAddressType string,
 	urlSchema string,
 	port int,
+	statusChecks StatusCheckConfig,
 ) *Controller {
-	instanceAddressType = strings.ToLower(strings.TrimSpace(instanceAddressType))
-	if instanceAddressType != "private" && instanceAddressType != "public" {
-		log.Panicf("invalid instanceAddressType %v\n", instanceAddressType)
+	endpoint, err := newEndpoint(instanceAddressType, urlSchema, port)
+	if err != nil {
+		log.Panicln(err)
 	}
 	// The session.NewSession function automatically handles AWS credentials using the default credential provider chain.
 	// This means that the AWS credentials can be obtained from multiple sources such as environment variables,
@@ -42,59 +48,85 @@ func MustNewController(
 	if err != nil {
 		log.Panicln(fmt.Errorf("failed to create ec2 controller: %w", err))
 	}
-	instance := &Controller{region: region, instanceId: instanceId, client: ec2.New(sess)}
-	if err := instance.updateState(instanceAddressType, urlSchema, port); err != nil {
+	instance := &Controller{
+		region:       region,
+		instanceId:   instanceId,
+		endpoint:     endpoint,
+		statusChecks: statusChecks,
+		client:       ec2.New(sess),
+	}
+	if err := instance.updateState(); err != nil {
 		log.Panicln(fmt.Errorf("failed to update ec2 controller: %w", err))
 	}
 	return instance
 }
 
-func (c *Controller) IpAddress() string { return c.ipAddress }
+func (c *Controller) IpAddress() string {
+	c.addressMu.RLock()
+	defer c.addressMu.RUnlock()
+	return c.ipAddress
+}
 
-func (c *Controller) updateState(instanceAddressType string, urlSchema string, port int) error {
+func (c *Controller) updateState() error {
 	instance, err := c.findInstance()
-	if err == nil {
-		c.running = aws.StringValue(instance.State.Name) == "running" || aws.StringValue(instance.State.Name) == "pending"
-		c.ipAddress = findAddress(instance, instanceAddressType, urlSchema, port)
-		if len(c.ipAddress) == 0 {
-			return errors.New("failed to retrieve instance address")
+	if err != nil {
+		return err
+	}
+	state := aws.StringValue(instance.State.Name)
+	c.instanceType = aws.StringValue(instance.InstanceType)
+	// A pending instance has no address yet. StartIfNotRunning waits for it to run and resolves its address.
+	c.running = state == ec2.InstanceStateNameRunning
+	// The address of a stopped instance may change on the next start. It is resolved in StartIfNotRunning.
+	if c.running {
+		if err := c.updateAddress(instance); err != nil {
+			return err
 		}
-		log.Printf("prover instance ip address %s\n", c.ipAddress)
+		c.emit(c.event(EventStart))
 	}
-	return err
+	return nil
 }
 
-func (c *Controller) findInstance() (*ec2.Instance, error) {
-	output, err := c.client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: c.instanceIds()})
-	if err != nil || len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
-		return nil, err
+func (c *Controller) event(eventType EventType) Event {
+	return Event{
+		Type:         eventType,
+		InstanceId:   c.instanceId,
+		InstanceType: c.instanceType,
+		Region:       c.region,
+		Address:      c.IpAddress(),
 	}
-	return output.Reservations[0].Instances[0], nil
 }
 
-func findAddress(instance *ec2.Instance, instanceAddressType string, schema string, port int) (address string) {
-	for _, networkInterface := range instance.NetworkInterfaces {
-		if networkInterface != nil {
-			for _, ipAddress := range networkInterface.PrivateIpAddresses {
-				if ipAddress != nil {
-					switch instanceAddressType {
-					case "private":
-						address = aws.StringValue(ipAddress.PrivateIpAddress)
-					case "public":
-						if ipAddress.Association != nil {
-							address = aws.StringValue(ipAddress.Association.PublicIp)
-						}
-					}
-					if len(address) != 0 {
-						return schema + "://" + address + ":" + strconv.Itoa(port)
-					}
-				}
-			}
+func (c *Controller) updateAddress(instance *ec2.Instance) error {
+	address, err := c.endpoint.resolve(c.client, instance)
+	if err != nil {
+		return err
+	}
+	c.addressMu.Lock()
+	previous := c.ipAddress
+	c.ipAddress = address
+	c.addressMu.Unlock()
+	if previous != address {
+		log.Printf("prover instance ip address %s\n", address)
+		if len(previous) != 0 {
+			c.emit(c.event(EventAddressChange))
 		}
 	}
-	return
+	return nil
+}
+
+func (c *Controller) findInstance() (*ec2.Instance, error) {
+	output, err := c.client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: c.instanceIds()})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
+		return nil, errors.New("instance not found")
+	}
+	return output.Reservations[0].Instances[0], nil
 }
 
+// StartIfNotRunning starts the instance and waits for it to pass its status checks. A failed start stops the
+// instance again. The start is canceled by StopIfRunning and CancelStart.
 func (c *Controller) StartIfNotRunning() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -102,39 +134,86 @@ func (c *Controller) StartIfNotRunning() error {
 		log.Println("instance is already running")
 		return nil
 	}
+	ctx, done := c.begin()
+	defer done()
 	for {
 		instance, err := c.findInstance()
 		if err != nil {
 			return fmt.Errorf("failed to read ec2 instance info %s: %w", c.instanceId, err)
 		}
-		if *instance.State.Name == ec2.InstanceStateNameStopped {
+		state := aws.StringValue(instance.State.Name)
+		if state == ec2.InstanceStateNamePending || state == ec2.InstanceStateNameRunning {
+			// The instance is started already, e.g. by the previous process. Only its start is waited for.
+			log.Printf("instance is %s (id: %s)", state, c.instanceId)
+			break
+		}
+		if state == ec2.InstanceStateNameStopped {
+			_, err := c.client.StartInstances(&ec2.StartInstancesInput{InstanceIds: c.instanceIds()})
+			log.Printf("start instance (id: %s)", c.instanceId)
+			if err != nil {
+				log.Println(fmt.Errorf("failed to start ec2 instance %s: %w", c.instanceId, err))
+				return err
+			}
 			break
 		}
-		time.Sleep(1 * time.Second)
+		if err := sleep(ctx, 1*time.Second); err != nil {
+			return fmt.Errorf("start of ec2 instance %s is canceled: %w", c.instanceId, err)
+		}
 	}
-	_, err := c.client.StartInstances(&ec2.StartInstancesInput{InstanceIds: c.instanceIds()})
-	log.Printf("start instance (id: %s)", c.instanceId)
-	if err != nil {
-		log.Println(fmt.Errorf("failed to start ec2 instance %s: %w", c.instanceId, err))
+	c.running = true
+	if err := c.waitUntilReady(ctx); err != nil {
+		// The instance is stopped so that it does not run unused, and the next start starts it again.
+		if stopErr := c.stop(); stopErr != nil {
+			log.Println(fmt.Errorf("failed to stop ec2 instance %s: %w", c.instanceId, stopErr))
+		}
+		c.running = false
 		return err
 	}
-	c.running = true
 	return nil
 }
 
+// waitUntilReady waits for the started instance to be running and to pass its status checks. c.mu must be held.
+func (c *Controller) waitUntilReady(ctx context.Context) error {
+	instance, err := waitUntilRunning(ctx, c.client, c.instanceId)
+	if err != nil {
+		return fmt.Errorf("failed to wait for ec2 instance %s: %w", c.instanceId, err)
+	}
+	c.instanceType = aws.StringValue(instance.InstanceType)
+	if err := c.updateAddress(instance); err != nil {
+		return fmt.Errorf("failed to resolve address of ec2 instance %s: %w", c.instanceId, err)
+	}
+	c.emit(c.event(EventStart))
+	return waitUntilStatusOk(ctx, c.client, c.instanceId, c.statusChecks, c.onImpaired)
+}
+
+func (c *Controller) onImpaired(err *ImpairedError) {
+	event := c.event(EventImpaired)
+	event.Reason = err.Error()
+	c.emit(event)
+}
+
 func (c *Controller) StopIfRunning() {
+	c.CancelStart()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.running {
-		log.Printf("stop instance (id: %s)", c.instanceId)
-		_, err := c.client.StopInstances(&ec2.StopInstancesInput{InstanceIds: c.instanceIds()})
-		if err == nil {
-			c.running = false
-		} else {
+		if err := c.stop(); err != nil {
 			log.Println(fmt.Errorf("failed to stop ec2 instance %s: %w", c.instanceId, err))
 		}
 	}
 }
 
+// stop stops the instance. c.mu must be held.
+func (c *Controller) stop() error {
+	log.Printf("stop instance (id: %s)", c.instanceId)
+	if _, err := c.client.StopInstances(&ec2.StopInstancesInput{InstanceIds: c.instanceIds()}); err != nil {
+		return err
+	}
+	c.running = false
+	c.emit(c.event(EventStop))
+	return nil
+}
+
 func (c *Controller) instanceIds() []*string { return []*string{&c.instanceId} }
 func (c *Controller) Running() bool          { return c.running }
+func (c *Controller) Region() string         { return c.region }
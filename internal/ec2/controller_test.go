@@ -0,0 +1,200 @@
+package ec2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeEC2 serves the EC2 query API for the instances of the tests.
+type fakeEC2 struct {
+	mu sync.Mutex
+	// states are the states of the instances, e.g. "stopped".
+	states map[string]string
+	// statuses are the summaries of the status checks of the instances, e.g. "impaired". An instance without
+	// a summary has no status yet.
+	statuses map[string]string
+	// calls are the number of calls of each action.
+	calls map[string]int
+	// desired is the desired capacity of each Auto Scaling Group. The groups have no instances.
+	desired map[string]int64
+}
+
+// newFakeEC2 returns a fake EC2 API and a client of it. The waits are shortened for the test.
+func newFakeEC2(t *testing.T) (*fakeEC2, *ec2.EC2) {
+	fake := &fakeEC2{states: make(map[string]string), statuses: make(map[string]string), calls: make(map[string]int), desired: make(map[string]int64)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	shortenWaits(t)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	return fake, ec2.New(sess)
+}
+
+func shortenWaits(t *testing.T) {
+	delay, running, grace, healthy := waiterDelay, runningTimeout, rebootGracePeriod, healthyTimeout
+	waiterDelay, runningTimeout, rebootGracePeriod, healthyTimeout = 10*time.Millisecond, time.Second, 10*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() {
+		waiterDelay, runningTimeout, rebootGracePeriod, healthyTimeout = delay, running, grace, healthy
+	})
+}
+
+func (f *fakeEC2) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	_ = request.ParseForm()
+	action, instanceId := request.Form.Get("Action"), request.Form.Get("InstanceId.1")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[action]++
+	switch action {
+	case "DescribeInstances":
+		fmt.Fprintf(writer, `<DescribeInstancesResponse><reservationSet><item><instancesSet><item>
+<instanceId>%s</instanceId><instanceType>t3.micro</instanceType><instanceState><name>%s</name></instanceState>
+<privateIpAddress>10.0.0.1</privateIpAddress></item></instancesSet></item></reservationSet></DescribeInstancesResponse>`,
+			instanceId, f.states[instanceId])
+		// A pending instance runs once it is seen.
+		if f.states[instanceId] == ec2.InstanceStateNamePending {
+			f.states[instanceId] = ec2.InstanceStateNameRunning
+		}
+	case "DescribeInstanceStatus":
+		fmt.Fprint(writer, `<DescribeInstanceStatusResponse><instanceStatusSet>`)
+		if status, ok := f.statuses[instanceId]; ok {
+			check := "passed"
+			if status == ec2.SummaryStatusImpaired {
+				check = ec2.StatusTypeFailed
+			}
+			fmt.Fprintf(writer, `<item><instanceId>%s</instanceId><systemStatus><status>ok</status></systemStatus>
+<instanceStatus><status>%s</status><details><item><name>reachability</name><status>%s</status></item></details></instanceStatus></item>`,
+				instanceId, status, check)
+		}
+		fmt.Fprint(writer, `</instanceStatusSet></DescribeInstanceStatusResponse>`)
+	case "StartInstances":
+		f.states[instanceId] = ec2.InstanceStateNameRunning
+		fmt.Fprint(writer, `<StartInstancesResponse><instancesSet/></StartInstancesResponse>`)
+	case "StopInstances":
+		f.states[instanceId] = ec2.InstanceStateNameStopped
+		fmt.Fprint(writer, `<StopInstancesResponse><instancesSet/></StopInstancesResponse>`)
+	case "RebootInstances":
+		fmt.Fprint(writer, `<RebootInstancesResponse><return>true</return></RebootInstancesResponse>`)
+	case "DescribeAutoScalingGroups":
+		groupName := request.Form.Get("AutoScalingGroupNames.member.1")
+		fmt.Fprintf(writer, `<DescribeAutoScalingGroupsResponse><DescribeAutoScalingGroupsResult><AutoScalingGroups><member>
+<AutoScalingGroupName>%s</AutoScalingGroupName><MinSize>0</MinSize><MaxSize>4</MaxSize><DesiredCapacity>%d</DesiredCapacity>
+<Instances/></member></AutoScalingGroups></DescribeAutoScalingGroupsResult></DescribeAutoScalingGroupsResponse>`,
+			groupName, f.desired[groupName])
+	case "SetDesiredCapacity":
+		desired, _ := strconv.ParseInt(request.Form.Get("DesiredCapacity"), 10, 64)
+		f.desired[request.Form.Get("AutoScalingGroupName")] = desired
+		fmt.Fprint(writer, `<SetDesiredCapacityResponse/>`)
+	default:
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(writer, `<Response><Errors><Error><Code>InvalidAction</Code><Message>%s</Message></Error></Errors></Response>`, action)
+	}
+}
+
+func (f *fakeEC2) set(instanceId string, state string, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[instanceId] = state
+	if len(status) == 0 {
+		delete(f.statuses, instanceId)
+	} else {
+		f.statuses[instanceId] = status
+	}
+}
+
+func (f *fakeEC2) count(action string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[action]
+}
+
+func newTestController(client *ec2.EC2, instanceId string, statusChecks StatusCheckConfig) *Controller {
+	endpoint, _ := newEndpoint(AddressTypePrivate, "http", 3030)
+	return &Controller{client: client, region: "us-east-1", instanceId: instanceId, endpoint: endpoint, statusChecks: statusChecks}
+}
+
+func TestControllerStart(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameStopped, ec2.SummaryStatusOk)
+	c := newTestController(client, "i-1", StatusCheckConfig{Timeout: time.Second})
+	if err := c.StartIfNotRunning(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Running() || c.IpAddress() != "http://10.0.0.1:3030" {
+		t.Errorf("started instance must be running at its address. got running %v, address %s", c.Running(), c.IpAddress())
+	}
+}
+
+func TestControllerStartsPendingInstance(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNamePending, ec2.SummaryStatusOk)
+	c := newTestController(client, "i-1", StatusCheckConfig{Timeout: time.Second})
+	if err := c.updateState(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Running() {
+		t.Fatal("pending instance must not be running")
+	}
+	if err := c.StartIfNotRunning(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Running() || c.IpAddress() != "http://10.0.0.1:3030" || fake.count("StartInstances") != 0 {
+		t.Errorf("pending instance must be waited for and resolved. running %v, address %s, starts %d",
+			c.Running(), c.IpAddress(), fake.count("StartInstances"))
+	}
+}
+
+func TestControllerStopsImpairedInstance(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameStopped, ec2.SummaryStatusImpaired)
+	c := newTestController(client, "i-1", StatusCheckConfig{Timeout: time.Second})
+	var events []EventType
+	c.Subscribe(func(event Event) { events = append(events, event.Type) })
+
+	err := c.StartIfNotRunning()
+	var impaired *ImpairedError
+	if !errors.As(err, &impaired) {
+		t.Fatalf("start of an impaired instance must fail. got %v", err)
+	}
+	if c.Running() || fake.count("StopInstances") != 1 {
+		t.Errorf("impaired instance must be stopped. running %v, stops %d", c.Running(), fake.count("StopInstances"))
+	}
+	expected := []EventType{EventStart, EventImpaired, EventStop}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("events mismatch. expected %v, but got %v", expected, events)
+	}
+}
+
+func TestStopCancelsStart(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameStopped, ec2.SummaryStatusInitializing)
+	c := newTestController(client, "i-1", StatusCheckConfig{Timeout: time.Minute})
+	started := make(chan error, 1)
+	go func() { started <- c.StartIfNotRunning() }()
+	for fake.count("DescribeInstanceStatus") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := time.Now()
+	c.StopIfRunning()
+	if err := <-started; err == nil {
+		t.Errorf("canceled start must fail")
+	}
+	if time.Since(stopped) > 10*time.Second || c.Running() {
+		t.Errorf("stop must cancel the start and stop the instance. running %v", c.Running())
+	}
+}
@@ -0,0 +1,72 @@
+package ec2
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventStart         EventType = "start"
+	EventStop          EventType = "stop"
+	EventAddressChange EventType = "addressChange"
+	// EventImpaired is emitted when a started instance fails its status checks.
+	EventImpaired EventType = "impaired"
+)
+
+// Event is emitted by controllers when an instance starts, stops, changes its address or is impaired.
+type Event struct {
+	Type         EventType `json:"type"`
+	InstanceId   string    `json:"instanceId"`
+	InstanceType string    `json:"instanceType,omitempty"`
+	Region       string    `json:"region,omitempty"`
+	Address      string    `json:"address,omitempty"`
+	// Reason describes the failed status checks of an impaired instance.
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// feed delivers controller events to subscribers.
+type feed struct {
+	mu          sync.Mutex
+	subscribers []func(Event)
+	// running keeps the start event of running instances so that late subscribers see them.
+	running map[string]Event
+}
+
+// Subscribe registers fn to be called on every event. Start events of instances
+// that are already running are delivered immediately.
+func (f *feed) Subscribe(fn func(Event)) {
+	f.mu.Lock()
+	f.subscribers = append(f.subscribers, fn)
+	running := make([]Event, 0, len(f.running))
+	for _, event := range f.running {
+		running = append(running, event)
+	}
+	f.mu.Unlock()
+	for _, event := range running {
+		fn(event)
+	}
+}
+
+func (f *feed) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	f.mu.Lock()
+	switch event.Type {
+	case EventStart:
+		if f.running == nil {
+			f.running = make(map[string]Event)
+		}
+		f.running[event.InstanceId] = event
+	case EventStop:
+		delete(f.running, event.InstanceId)
+	}
+	subscribers := append([]func(Event){}, f.subscribers...)
+	f.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
@@ -0,0 +1,158 @@
+package ec2
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailoverTarget is a set of prover instances in a region. The instances of the set are started in order until one
+// has capacity, and only one instance runs at a time.
+type FailoverTarget struct {
+	Region      string
+	InstanceIds []string
+}
+
+// ParseFailoverTarget parses a target in the form of "<region>=<instance id>[+<instance id>...]".
+func ParseFailoverTarget(value string) (FailoverTarget, error) {
+	region, ids, ok := strings.Cut(value, "=")
+	target := FailoverTarget{Region: strings.TrimSpace(region)}
+	for _, instanceId := range strings.Split(ids, "+") {
+		if instanceId = strings.TrimSpace(instanceId); len(instanceId) == 0 {
+			ok = false
+		}
+		target.InstanceIds = append(target.InstanceIds, instanceId)
+	}
+	if !ok || len(target.Region) == 0 {
+		return FailoverTarget{}, fmt.Errorf("invalid failover target %s: expected <region>=<instance id>[+<instance id>...]", value)
+	}
+	return target, nil
+}
+
+// FailoverController starts the first prover instance in order which has capacity, trying the instances of each
+// target before the next region. The service moves to the next region with Failover if the prover of the active
+// instance does not become ready.
+type FailoverController struct {
+	feed
+	targets []FailoverTarget
+	// controllers are the instances of the targets in order. regions are the indexes of their targets.
+	controllers  []*Controller
+	regions      []int
+	active       atomic.Int32
+	readyTimeout time.Duration
+	mu           sync.Mutex
+}
+
+func MustNewFailoverController(
+	targets []FailoverTarget,
+	instanceAddressType string,
+	urlSchema string,
+	port int,
+	readyTimeout time.Duration,
+	statusChecks StatusCheckConfig,
+) *FailoverController {
+	if len(targets) == 0 {
+		log.Panicln("failover targets are empty")
+	}
+	c := &FailoverController{targets: targets, readyTimeout: readyTimeout}
+	running := -1
+	for i, target := range targets {
+		for _, instanceId := range target.InstanceIds {
+			controller := MustNewController(target.Region, instanceId, instanceAddressType, urlSchema, port, statusChecks)
+			controller.Subscribe(c.emit)
+			if running < 0 && controller.Running() {
+				running = len(c.controllers)
+			}
+			c.controllers = append(c.controllers, controller)
+			c.regions = append(c.regions, i)
+		}
+	}
+	// An instance left running by the previous process keeps serving.
+	if running >= 0 {
+		c.active.Store(int32(running))
+	}
+	return c
+}
+
+func (c *FailoverController) current() *Controller {
+	return c.controllers[c.active.Load()]
+}
+
+// StartIfNotRunning starts the active instance. If it has no capacity, or it is impaired, the next instance is started.
+func (c *FailoverController) StartIfNotRunning() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for i := int(c.active.Load()); i < len(c.controllers); i++ {
+		c.active.Store(int32(i))
+		err := c.controllers[i].StartIfNotRunning()
+		if err == nil {
+			return nil
+		}
+		var impaired *ImpairedError
+		if !isCapacityError(err) && !errors.As(err, &impaired) {
+			return err
+		}
+		log.Println(fmt.Errorf("instance %s in region %s is unavailable: %w", c.controllers[i].instanceId, c.region(i), err))
+		errs = append(errs, err)
+	}
+	c.active.Store(0)
+	return fmt.Errorf("no available instance in any failover region: %w", errors.Join(errs...))
+}
+
+// Active returns the position of the active instance, which is passed to Failover.
+func (c *FailoverController) Active() int { return int(c.active.Load()) }
+
+// Failover stops the instance at from, as returned by Active, and makes the first instance of the next region active.
+// If another caller has already failed over from it, the active instance is kept, so that concurrent callers which
+// saw the same timeout fail over only once. It returns false if from is in the last region.
+func (c *FailoverController) Failover(from int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int(c.active.Load()) != from {
+		return true
+	}
+	c.controllers[from].StopIfRunning()
+	next := from + 1
+	for next < len(c.controllers) && c.regions[next] == c.regions[from] {
+		next++
+	}
+	if next >= len(c.controllers) {
+		c.active.Store(0)
+		return false
+	}
+	log.Printf("fail over from region %s to %s\n", c.region(from), c.region(next))
+	c.active.Store(int32(next))
+	return true
+}
+
+// StopIfRunning stops the active instance. The next start tries the primary target first.
+func (c *FailoverController) StopIfRunning() {
+	c.CancelStart()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current().StopIfRunning()
+	if !c.current().Running() {
+		c.active.Store(0)
+	}
+}
+
+// CancelStart makes the start in progress, if any, fail.
+func (c *FailoverController) CancelStart() {
+	for _, controller := range c.controllers {
+		controller.CancelStart()
+	}
+}
+
+// ReadyTimeout is the time to wait for the prover server before failing over.
+func (c *FailoverController) ReadyTimeout() time.Duration { return c.readyTimeout }
+func (c *FailoverController) IpAddress() string           { return c.current().IpAddress() }
+func (c *FailoverController) Running() bool               { return c.current().Running() }
+func (c *FailoverController) Region() string              { return c.region(int(c.active.Load())) }
+
+// region returns the region of the instance at i.
+func (c *FailoverController) region(i int) string { return c.targets[c.regions[i]].Region }
@@ -0,0 +1,95 @@
+package ec2
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// newTestFailoverController returns a controller with a target of the instances of each set, in region-<first instance>.
+func newTestFailoverController(client *ec2.EC2, sets ...[]string) *FailoverController {
+	c := &FailoverController{}
+	for i, instanceIds := range sets {
+		c.targets = append(c.targets, FailoverTarget{Region: "region-" + instanceIds[0], InstanceIds: instanceIds})
+		for _, instanceId := range instanceIds {
+			c.controllers = append(c.controllers, newTestController(client, instanceId, StatusCheckConfig{Timeout: time.Second}))
+			c.regions = append(c.regions, i)
+		}
+	}
+	return c
+}
+
+func TestParseFailoverTarget(t *testing.T) {
+	target, err := ParseFailoverTarget(" us-east-1 = i-1 + i-2 ")
+	if err != nil || target.Region != "us-east-1" || len(target.InstanceIds) != 2 || target.InstanceIds[1] != "i-2" {
+		t.Errorf("unexpected target %+v, err %v", target, err)
+	}
+	for _, invalid := range []string{"us-east-1", "=i-1", "us-east-1=", "us-east-1=i-1+"} {
+		if _, err := ParseFailoverTarget(invalid); err == nil {
+			t.Errorf("invalid target %s must be rejected", invalid)
+		}
+	}
+}
+
+func TestFailoverWithinInstanceSet(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameStopped, ec2.SummaryStatusImpaired)
+	fake.set("i-2", ec2.InstanceStateNameStopped, ec2.SummaryStatusOk)
+	fake.set("i-3", ec2.InstanceStateNameStopped, ec2.SummaryStatusOk)
+	c := newTestFailoverController(client, []string{"i-1", "i-2"}, []string{"i-3"})
+	if err := c.StartIfNotRunning(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Region() != "region-i-1" || !c.controllers[1].Running() {
+		t.Errorf("next instance of the set must be started. region %s", c.Region())
+	}
+}
+
+func TestConcurrentFailover(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	for _, instanceId := range []string{"i-1", "i-2", "i-3", "i-4"} {
+		fake.set(instanceId, ec2.InstanceStateNameStopped, ec2.SummaryStatusOk)
+	}
+	c := newTestFailoverController(client, []string{"i-1", "i-2"}, []string{"i-3"}, []string{"i-4"})
+	if err := c.StartIfNotRunning(); err != nil {
+		t.Fatal(err)
+	}
+	// Two jobs time out in the primary region at the same time.
+	from := c.Active()
+	if !c.Failover(from) || !c.Failover(from) {
+		t.Fatal("failover must succeed while there is a next region")
+	}
+	if c.Region() != "region-i-3" || fake.count("StopInstances") != 1 {
+		t.Errorf("concurrent failovers from a region must move once, to the next region. region %s, stops %d", c.Region(), fake.count("StopInstances"))
+	}
+}
+
+func TestFailoverFromImpairedInstance(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameStopped, ec2.SummaryStatusImpaired)
+	fake.set("i-2", ec2.InstanceStateNameStopped, ec2.SummaryStatusOk)
+	c := newTestFailoverController(client, []string{"i-1"}, []string{"i-2"})
+	if err := c.StartIfNotRunning(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Region() != "region-i-2" || !c.Running() || c.controllers[0].Running() {
+		t.Errorf("impaired instance must be stopped and the next region started. region %s", c.Region())
+	}
+}
+
+func TestFailoverAllImpaired(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameStopped, ec2.SummaryStatusImpaired)
+	fake.set("i-2", ec2.InstanceStateNameStopped, ec2.SummaryStatusImpaired)
+	c := newTestFailoverController(client, []string{"i-1"}, []string{"i-2"})
+	err := c.StartIfNotRunning()
+	var impaired *ImpairedError
+	if !errors.As(err, &impaired) {
+		t.Fatalf("start must fail with the impaired instances. got %v", err)
+	}
+	if c.Region() != "region-i-1" || fake.count("StopInstances") != 2 {
+		t.Errorf("next start must try the primary region with every instance stopped. region %s, stops %d", c.Region(), fake.count("StopInstances"))
+	}
+}
@@ -0,0 +1,356 @@
+package ec2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	interruptionPollInterval = 5 * time.Second
+	// OwnerTagKey is the tag set on launched instances. Its value identifies the proxy that owns them.
+	OwnerTagKey = "kroma-prover-proxy"
+)
+
+type LaunchConfig struct {
+	// LaunchTemplate is a launch template id (lt-...) or name. ImageId and InstanceType are used without it.
+	LaunchTemplate        string
+	LaunchTemplateVersion string
+	ImageId               string
+	InstanceType          string
+	SubnetId              string
+	SecurityGroupIds      []string
+	// Owner is the value of the OwnerTagKey tag. Instances carrying it are terminated on startup.
+	Owner string
+	Spot  bool
+	// MaxSpotFailures is the number of consecutive spot launch failures and interruptions
+	// after which instances are launched on-demand.
+	MaxSpotFailures int
+	StatusChecks    StatusCheckConfig
+}
+
+// LaunchController launches a new instance with RunInstances when work arrives and terminates it when idle.
+type LaunchController struct {
+	feed
+	pendingStart
+	client        *ec2.EC2
+	region        string
+	config        LaunchConfig
+	endpoint      endpoint
+	instanceId    string
+	instanceType  string
+	spot          bool
+	spotRequestId string
+	spotFailures  int
+	ipAddress     string
+	running       bool
+	interrupted   chan struct{}
+	stopWatch     context.CancelFunc
+	mu            sync.Mutex
+	addressMu     sync.RWMutex
+}
+
+func MustNewLaunchController(
+	region string,
+	config LaunchConfig,
+	instanceAddressType string,
+	urlSchema string,
+	port int,
+) *LaunchController {
+	if len(config.LaunchTemplate) == 0 && (len(config.ImageId) == 0 || len(config.InstanceType) == 0) {
+		log.Panicln("either launch template or image id with instance type is required")
+	}
+	if len(config.Owner) == 0 {
+		log.Panicln("launch owner is required")
+	}
+	endpoint, err := newEndpoint(instanceAddressType, urlSchema, port)
+	if err != nil {
+		log.Panicln(err)
+	}
+	sess, err := session.NewSession(&aws.Config{Region: &region})
+	if err != nil {
+		log.Panicln(fmt.Errorf("failed to create ec2 launch controller: %w", err))
+	}
+	controller := &LaunchController{client: ec2.New(sess), region: region, config: config, endpoint: endpoint}
+	if err := controller.terminateOrphans(); err != nil {
+		log.Panicln(fmt.Errorf("failed to clean up orphan instances: %w", err))
+	}
+	return controller
+}
+
+// terminateOrphans terminates instances left by a previous run of the proxy with the same owner.
+func (c *LaunchController) terminateOrphans() error {
+	var orphans []*string
+	err := c.client.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + OwnerTagKey), Values: []*string{aws.String(c.config.Owner)}},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{
+				ec2.InstanceStateNamePending,
+				ec2.InstanceStateNameRunning,
+				ec2.InstanceStateNameStopping,
+				ec2.InstanceStateNameStopped,
+			})},
+		},
+	}, func(output *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				orphans = append(orphans, instance.InstanceId)
+			}
+		}
+		return true
+	})
+	if err != nil || len(orphans) == 0 {
+		return err
+	}
+	log.Printf("terminate orphan instances %v", aws.StringValueSlice(orphans))
+	_, err = c.client.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: orphans})
+	return err
+}
+
+func (c *LaunchController) IpAddress() string {
+	c.addressMu.RLock()
+	defer c.addressMu.RUnlock()
+	return c.ipAddress
+}
+
+func (c *LaunchController) Running() bool  { return c.running }
+func (c *LaunchController) Region() string { return c.region }
+
+// Interruption returns a channel that is closed when the running spot instance is interrupted.
+func (c *LaunchController) Interruption() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.interrupted
+}
+
+// StartIfNotRunning launches an instance and waits for it to pass its status checks. A failed start terminates
+// the instance. The start is canceled by StopIfRunning and CancelStart.
+func (c *LaunchController) StartIfNotRunning() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		log.Println("instance is already running")
+		return nil
+	}
+	ctx, done := c.begin()
+	defer done()
+	instance, err := c.launch()
+	if err != nil {
+		return err
+	}
+	c.instanceId = aws.StringValue(instance.InstanceId)
+	c.instanceType = aws.StringValue(instance.InstanceType)
+	c.spotRequestId = aws.StringValue(instance.SpotInstanceRequestId)
+	c.running = true
+	c.interrupted = make(chan struct{})
+	if err := c.waitUntilRunning(ctx); err != nil {
+		c.terminate()
+		return fmt.Errorf("failed to resolve address of ec2 instance %s: %w", c.instanceId, err)
+	}
+	if c.spot {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.stopWatch = cancel
+		go c.watchInterruption(ctx, c.instanceId, c.spotRequestId, c.interrupted)
+	}
+	c.emit(c.event(EventStart))
+	if err := waitUntilStatusOk(ctx, c.client, c.instanceId, c.config.StatusChecks, c.onImpaired); err != nil {
+		c.terminate()
+		return err
+	}
+	if c.spot {
+		// Spot failures are counted while they are consecutive.
+		c.spotFailures = 0
+	}
+	return nil
+}
+
+func (c *LaunchController) onImpaired(err *ImpairedError) {
+	event := c.event(EventImpaired)
+	event.Reason = err.Error()
+	c.emit(event)
+}
+
+func (c *LaunchController) StopIfRunning() {
+	c.CancelStart()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		if !c.spot && c.spotFailures >= c.config.MaxSpotFailures {
+			// The on-demand fallback is used until the burst is over. Spot is tried again for the next one.
+			c.spotFailures = 0
+		}
+		c.terminate()
+	}
+}
+
+func (c *LaunchController) launch() (*ec2.Instance, error) {
+	c.spot = c.config.Spot && c.spotFailures < c.config.MaxSpotFailures
+	input := &ec2.RunInstancesInput{
+		MinCount: aws.Int64(1),
+		MaxCount: aws.Int64(1),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeInstance),
+			Tags: []*ec2.Tag{
+				{Key: aws.String(OwnerTagKey), Value: aws.String(c.config.Owner)},
+				{Key: aws.String("Name"), Value: aws.String("kroma-prover-" + c.config.Owner)},
+			},
+		}},
+	}
+	if len(c.config.LaunchTemplate) != 0 {
+		input.LaunchTemplate = &ec2.LaunchTemplateSpecification{}
+		if strings.HasPrefix(c.config.LaunchTemplate, "lt-") {
+			input.LaunchTemplate.LaunchTemplateId = aws.String(c.config.LaunchTemplate)
+		} else {
+			input.LaunchTemplate.LaunchTemplateName = aws.String(c.config.LaunchTemplate)
+		}
+		if len(c.config.LaunchTemplateVersion) != 0 {
+			input.LaunchTemplate.Version = aws.String(c.config.LaunchTemplateVersion)
+		}
+	}
+	if len(c.config.ImageId) != 0 {
+		input.ImageId = aws.String(c.config.ImageId)
+	}
+	if len(c.config.InstanceType) != 0 {
+		input.InstanceType = aws.String(c.config.InstanceType)
+	}
+	if len(c.config.SubnetId) != 0 {
+		input.SubnetId = aws.String(c.config.SubnetId)
+	}
+	if len(c.config.SecurityGroupIds) != 0 {
+		input.SecurityGroupIds = aws.StringSlice(c.config.SecurityGroupIds)
+	}
+	if c.spot {
+		input.InstanceMarketOptions = &ec2.InstanceMarketOptionsRequest{
+			MarketType: aws.String(ec2.MarketTypeSpot),
+			SpotOptions: &ec2.SpotMarketOptions{
+				SpotInstanceType:             aws.String(ec2.SpotInstanceTypeOneTime),
+				InstanceInterruptionBehavior: aws.String(ec2.InstanceInterruptionBehaviorTerminate),
+			},
+		}
+	}
+	output, err := c.client.RunInstances(input)
+	if err != nil {
+		if c.spot && isCapacityError(err) {
+			c.spotFailures++
+			log.Printf("failed to launch spot instance (failures: %d): %v", c.spotFailures, err)
+			return c.launch()
+		}
+		return nil, fmt.Errorf("failed to run ec2 instance: %w", err)
+	}
+	if len(output.Instances) == 0 {
+		return nil, errors.New("no instance launched")
+	}
+	log.Printf("launch instance (id: %s, spot: %v)", aws.StringValue(output.Instances[0].InstanceId), c.spot)
+	return output.Instances[0], nil
+}
+
+// waitUntilRunning waits for the launched instance to be running and resolves its address.
+func (c *LaunchController) waitUntilRunning(ctx context.Context) error {
+	instance, err := waitUntilRunning(ctx, c.client, c.instanceId)
+	if err != nil {
+		return err
+	}
+	address, err := c.endpoint.resolve(c.client, instance)
+	if err != nil {
+		return err
+	}
+	c.addressMu.Lock()
+	c.ipAddress = address
+	c.addressMu.Unlock()
+	log.Printf("prover instance ip address %s\n", address)
+	return nil
+}
+
+// watchInterruption polls the spot request of the instance until it is interrupted or ctx is canceled.
+func (c *LaunchController) watchInterruption(ctx context.Context, instanceId, spotRequestId string, interrupted chan struct{}) {
+	ticker := time.NewTicker(interruptionPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			output, err := c.client.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+				SpotInstanceRequestIds: []*string{&spotRequestId},
+			})
+			if err != nil {
+				log.Println(fmt.Errorf("failed to describe spot request %s: %w", spotRequestId, err))
+				continue
+			}
+			if len(output.SpotInstanceRequests) == 0 || output.SpotInstanceRequests[0].Status == nil {
+				continue
+			}
+			code := aws.StringValue(output.SpotInstanceRequests[0].Status.Code)
+			if !isInterruptionCode(code) {
+				continue
+			}
+			log.Printf("spot instance interrupted (id: %s, code: %s)", instanceId, code)
+			c.mu.Lock()
+			if c.instanceId == instanceId {
+				c.spotFailures++
+				c.terminate()
+				close(interrupted)
+			}
+			c.mu.Unlock()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *LaunchController) event(eventType EventType) Event {
+	return Event{
+		Type:         eventType,
+		InstanceId:   c.instanceId,
+		InstanceType: c.instanceType,
+		Region:       c.region,
+		Address:      c.IpAddress(),
+	}
+}
+
+// terminate terminates the current instance. c.mu must be held.
+func (c *LaunchController) terminate() {
+	if c.stopWatch != nil {
+		c.stopWatch()
+		c.stopWatch = nil
+	}
+	log.Printf("terminate instance (id: %s)", c.instanceId)
+	if _, err := c.client.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: []*string{&c.instanceId}}); err != nil {
+		log.Println(fmt.Errorf("failed to terminate ec2 instance %s: %w", c.instanceId, err))
+	}
+	c.emit(c.event(EventStop))
+	c.running = false
+	c.instanceId = ""
+	c.addressMu.Lock()
+	c.ipAddress = ""
+	c.addressMu.Unlock()
+}
+
+func isCapacityError(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	switch awsErr.Code() {
+	case "InsufficientInstanceCapacity", "InsufficientCapacity", "SpotMaxPriceTooLow", "MaxSpotInstanceCountExceeded":
+		return true
+	}
+	return false
+}
+
+func isInterruptionCode(code string) bool {
+	if strings.HasSuffix(code, "-by-user") {
+		return false
+	}
+	return strings.HasPrefix(code, "marked-for-") ||
+		strings.HasPrefix(code, "instance-terminated-") ||
+		strings.HasPrefix(code, "instance-stopped-")
+}
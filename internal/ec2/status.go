@@ -0,0 +1,214 @@
+package ec2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The waits are variables so that tests can shorten them.
+var (
+	waiterDelay = 2 * time.Second
+	// runningTimeout is the time to wait for a started instance to leave the pending state.
+	runningTimeout = 10 * time.Minute
+	// rebootGracePeriod keeps the waiter from failing on the impaired result reported before the reboot.
+	// Status checks run every minute.
+	rebootGracePeriod = 2 * time.Minute
+	// healthyTimeout is the time to wait for a scaled out group to have a healthy instance,
+	// which includes the health check grace period of the group.
+	healthyTimeout = 15 * time.Minute
+)
+
+// StatusCheckConfig configures the EC2 status checks of started instances.
+type StatusCheckConfig struct {
+	// Timeout is the time to wait for both status checks to pass. Status checks are skipped if it is not positive.
+	Timeout time.Duration
+	// MaxReboots is the number of times an impaired instance is rebooted before its start fails.
+	MaxReboots int
+}
+
+// ImpairedError is returned if an instance fails its system or instance status check.
+type ImpairedError struct {
+	InstanceId     string
+	SystemStatus   string
+	InstanceStatus string
+	// FailedChecks are the failed checks, e.g. "instance reachability".
+	FailedChecks []string
+}
+
+func (e *ImpairedError) Error() string {
+	message := fmt.Sprintf("instance %s is impaired (system status: %s, instance status: %s)", e.InstanceId, e.SystemStatus, e.InstanceStatus)
+	if len(e.FailedChecks) != 0 {
+		message += fmt.Sprintf(". failed checks: %s", strings.Join(e.FailedChecks, ", "))
+	}
+	return message
+}
+
+// pendingStart cancels the waits of a start in progress, which holds the lock of its controller,
+// so that a stop or a shutdown does not wait for the status checks.
+type pendingStart struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// begin returns the context of a start and the function to call when the start is over.
+func (p *pendingStart) begin() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.mu.Lock()
+	p.cancel = cancel
+	p.mu.Unlock()
+	return ctx, func() {
+		p.mu.Lock()
+		p.cancel = nil
+		p.mu.Unlock()
+		cancel()
+	}
+}
+
+// CancelStart makes the start in progress, if any, fail.
+func (p *pendingStart) CancelStart() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+// sleep waits for duration or until ctx is done.
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitUntilRunning waits for the started instance to be running and returns it.
+func waitUntilRunning(ctx context.Context, client *ec2.EC2, instanceId string) (*ec2.Instance, error) {
+	input := &ec2.DescribeInstancesInput{InstanceIds: []*string{&instanceId}}
+	ctx, cancel := context.WithTimeout(ctx, runningTimeout)
+	defer cancel()
+	err := client.WaitUntilInstanceRunningWithContext(ctx, input, waiterOptions(runningTimeout)...)
+	if err != nil {
+		return nil, fmt.Errorf("instance %s is not running: %w", instanceId, err)
+	}
+	output, err := client.DescribeInstancesWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
+		return nil, errors.New("instance not found")
+	}
+	return output.Reservations[0].Instances[0], nil
+}
+
+// waitUntilStatusOk waits for both status checks of the running instance to pass. An impaired instance is rebooted
+// up to config.MaxReboots times. onImpaired is called with every impaired status found. The wait ends when ctx is done.
+func waitUntilStatusOk(ctx context.Context, client *ec2.EC2, instanceId string, config StatusCheckConfig, onImpaired func(*ImpairedError)) error {
+	if config.Timeout <= 0 {
+		return nil
+	}
+	input := &ec2.DescribeInstanceStatusInput{InstanceIds: []*string{&instanceId}}
+	for reboots := 0; ; reboots++ {
+		waitCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+		err := client.WaitUntilInstanceStatusOkWithContext(waitCtx, input, append(waiterOptions(config.Timeout), failOnImpaired)...)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("status checks of instance %s are canceled: %w", instanceId, ctx.Err())
+		}
+		impaired, describeErr := describeImpaired(ctx, client, instanceId)
+		if describeErr != nil {
+			log.Println(fmt.Errorf("failed to describe status of instance %s: %w", instanceId, describeErr))
+		}
+		if impaired == nil {
+			return fmt.Errorf("status checks of instance %s did not pass: %w", instanceId, err)
+		}
+		onImpaired(impaired)
+		if reboots >= config.MaxReboots {
+			return impaired
+		}
+		log.Println(fmt.Errorf("reboot impaired instance (%d/%d): %w", reboots+1, config.MaxReboots, impaired))
+		if _, err := client.RebootInstancesWithContext(ctx, &ec2.RebootInstancesInput{InstanceIds: []*string{&instanceId}}); err != nil {
+			return fmt.Errorf("failed to reboot impaired instance %s: %w", instanceId, err)
+		}
+		if err := sleep(ctx, rebootGracePeriod); err != nil {
+			return fmt.Errorf("status checks of instance %s are canceled: %w", instanceId, err)
+		}
+	}
+}
+
+// describeImpaired returns the impaired status of the instance, or nil if no status check failed.
+func describeImpaired(ctx context.Context, client *ec2.EC2, instanceId string) (*ImpairedError, error) {
+	output, err := client.DescribeInstanceStatusWithContext(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds:         []*string{&instanceId},
+		IncludeAllInstances: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range output.InstanceStatuses {
+		systemStatus := statusSummary(status.SystemStatus)
+		instanceStatus := statusSummary(status.InstanceStatus)
+		if systemStatus != ec2.SummaryStatusImpaired && instanceStatus != ec2.SummaryStatusImpaired {
+			continue
+		}
+		impaired := &ImpairedError{InstanceId: instanceId, SystemStatus: systemStatus, InstanceStatus: instanceStatus}
+		impaired.FailedChecks = append(failedChecks("system", status.SystemStatus), failedChecks("instance", status.InstanceStatus)...)
+		return impaired, nil
+	}
+	return nil, nil
+}
+
+func statusSummary(summary *ec2.InstanceStatusSummary) string {
+	if summary == nil {
+		return ""
+	}
+	return aws.StringValue(summary.Status)
+}
+
+func failedChecks(kind string, summary *ec2.InstanceStatusSummary) []string {
+	if summary == nil {
+		return nil
+	}
+	var checks []string
+	for _, detail := range summary.Details {
+		if aws.StringValue(detail.Status) == ec2.StatusTypeFailed {
+			checks = append(checks, kind+" "+aws.StringValue(detail.Name))
+		}
+	}
+	return checks
+}
+
+// failOnImpaired stops the status ok waiter as soon as a check fails instead of waiting until the timeout.
+func failOnImpaired(w *request.Waiter) {
+	for _, argument := range []string{"InstanceStatuses[].SystemStatus.Status", "InstanceStatuses[].InstanceStatus.Status"} {
+		w.Acceptors = append(w.Acceptors, request.WaiterAcceptor{
+			State:    request.FailureWaiterState,
+			Matcher:  request.PathAnyWaiterMatch,
+			Argument: argument,
+			Expected: ec2.SummaryStatusImpaired,
+		})
+	}
+}
+
+// waiterOptions poll every waiterDelay until timeout. The default delay of the SDK waiters is 15 seconds.
+func waiterOptions(timeout time.Duration) []request.WaiterOption {
+	return []request.WaiterOption{
+		request.WithWaiterDelay(request.ConstantWaiterDelay(waiterDelay)),
+		request.WithWaiterMaxAttempts(int(timeout/waiterDelay) + 1),
+	}
+}
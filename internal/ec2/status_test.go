@@ -0,0 +1,56 @@
+package ec2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestWaitUntilStatusOk(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameRunning, ec2.SummaryStatusOk)
+	if err := waitUntilStatusOk(context.Background(), client, "i-1", StatusCheckConfig{Timeout: time.Second}, nil); err != nil {
+		t.Fatal(err)
+	}
+	fake.set("i-2", ec2.InstanceStateNameRunning, ec2.SummaryStatusInitializing)
+	err := waitUntilStatusOk(context.Background(), client, "i-2", StatusCheckConfig{Timeout: 50 * time.Millisecond}, nil)
+	var impaired *ImpairedError
+	if err == nil || errors.As(err, &impaired) {
+		t.Errorf("initializing instance must time out without being impaired. got %v", err)
+	}
+}
+
+func TestRebootImpairedInstance(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameRunning, ec2.SummaryStatusImpaired)
+	var found []*ImpairedError
+	err := waitUntilStatusOk(context.Background(), client, "i-1", StatusCheckConfig{Timeout: time.Second, MaxReboots: 2}, func(impaired *ImpairedError) {
+		found = append(found, impaired)
+	})
+	var impaired *ImpairedError
+	if !errors.As(err, &impaired) || impaired.InstanceStatus != ec2.SummaryStatusImpaired {
+		t.Fatalf("instance impaired after its reboots must fail. got %v", err)
+	}
+	if len(impaired.FailedChecks) != 1 || impaired.FailedChecks[0] != "instance reachability" {
+		t.Errorf("failed checks mismatch. got %v", impaired.FailedChecks)
+	}
+	if reboots := fake.count("RebootInstances"); reboots != 2 || len(found) != 3 {
+		t.Errorf("expected 2 reboots and 3 impaired statuses. got %d reboots and %d statuses", reboots, len(found))
+	}
+}
+
+func TestCancelStatusChecks(t *testing.T) {
+	fake, client := newFakeEC2(t)
+	fake.set("i-1", ec2.InstanceStateNameRunning, ec2.SummaryStatusInitializing)
+	var start pendingStart
+	ctx, done := start.begin()
+	defer done()
+	time.AfterFunc(50*time.Millisecond, start.CancelStart)
+	err := waitUntilStatusOk(ctx, client, "i-1", StatusCheckConfig{Timeout: time.Minute}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled status checks must fail with the cancellation. got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package proof
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	archiveVersion      = 1
+	archiveManifestName = "manifest.json"
+	archiveProofDir     = "proofs/"
+	// maxArchiveEntrySize protects the import from an entry that does not fit in memory.
+	maxArchiveEntrySize = 64 << 20
+)
+
+// ArchiveManifest is the first entry of a proof archive. It lists the proofs in the archive.
+type ArchiveManifest struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Proofs    []*ArchiveEntry `json:"proofs"`
+}
+
+type ArchiveEntry struct {
+	Id          string    `json:"id"`
+	BlockNumber string    `json:"blockNumber,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Checksum    string    `json:"checksum"`
+}
+
+type ImportResult struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Invalid    int `json:"invalid"`
+}
+
+// ExportArchive writes the proofs to a gzip compressed tar. The manifest is followed by proofs/<id>.json for each proof.
+// Proofs which are not found are skipped. It returns the number of exported proofs.
+func ExportArchive(writer io.Writer, repository Repository, ids []string) (int, error) {
+	manifest := &ArchiveManifest{Version: archiveVersion, CreatedAt: time.Now()}
+	for _, id := range ids {
+		proof := repository.Find(id)
+		if proof == nil {
+			log.Println("proof is not found. skip exporting it.", "id:", id)
+			continue
+		}
+		manifest.Proofs = append(manifest.Proofs, &ArchiveEntry{
+			Id:          id,
+			BlockNumber: proof.BlockNumber,
+			CreatedAt:   proof.CreatedAt,
+			Checksum:    proof.computeChecksum(),
+		})
+	}
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	if err := writeArchiveEntry(tarWriter, archiveManifestName, manifest); err != nil {
+		return 0, err
+	}
+	for _, entry := range manifest.Proofs {
+		proof := repository.Find(entry.Id)
+		if proof == nil {
+			return 0, fmt.Errorf("proof %s is deleted during the export", entry.Id)
+		}
+		// Proofs stored by older versions have no checksum.
+		proof.Checksum = entry.Checksum
+		if err := writeArchiveEntry(tarWriter, archiveProofDir+entry.Id+".json", proof); err != nil {
+			return 0, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close tar: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close gzip: %w", err)
+	}
+	return len(manifest.Proofs), nil
+}
+
+func writeArchiveEntry(writer *tar.Writer, name string, value any) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to json.Marshal %s: %w", name, err)
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), ModTime: time.Now()}
+	if err := writer.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header of %s: %w", name, err)
+	}
+	if _, err := writer.Write(body); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportArchive loads the proofs of an archive written by ExportArchive into the repository.
+// Each proof must be listed in the manifest with its checksum. Invalid proofs are skipped, as are proofs already stored.
+func ImportArchive(reader io.Reader, repository Repository) (*ImportResult, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if header.Name != archiveManifestName {
+		return nil, fmt.Errorf("the first entry %s is not the manifest", header.Name)
+	}
+	var manifest ArchiveManifest
+	if err := readArchiveEntry(tarReader, header, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+	expected := make(map[string]*ArchiveEntry)
+	for _, entry := range manifest.Proofs {
+		expected[entry.Id] = entry
+	}
+
+	result := &ImportResult{}
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		id, err := importArchiveEntry(tarReader, header, expected, repository)
+		switch {
+		case err != nil:
+			log.Println(fmt.Errorf("skip invalid archive entry %s: %w", header.Name, err))
+			result.Invalid++
+		case id == "":
+			result.Duplicates++
+		default:
+			result.Imported++
+		}
+	}
+	for id := range expected {
+		log.Println("proof listed in the manifest is missing in the archive.", "id:", id)
+		result.Invalid++
+	}
+	return result, nil
+}
+
+// importArchiveEntry saves the proof of the entry and returns its id. It returns an empty id if the proof is already stored.
+// The entry is removed from expected so that the remaining entries are missing in the archive.
+func importArchiveEntry(reader io.Reader, header *tar.Header, expected map[string]*ArchiveEntry, repository Repository) (string, error) {
+	name := strings.TrimPrefix(header.Name, archiveProofDir)
+	id := strings.TrimSuffix(name, ".json")
+	if name == header.Name || id == name || !validProofId(id) {
+		return "", fmt.Errorf("unexpected entry")
+	}
+	entry, ok := expected[id]
+	if !ok {
+		return "", fmt.Errorf("proof %s is not listed in the manifest", id)
+	}
+	delete(expected, id)
+	var proof FileProof
+	if err := readArchiveEntry(reader, header, &proof); err != nil {
+		return "", err
+	}
+	if checksum := proof.computeChecksum(); checksum != proof.Checksum || checksum != entry.Checksum {
+		return "", fmt.Errorf("checksum mismatch")
+	}
+	if repository.Meta(id) != nil {
+		return "", nil
+	}
+	if err := repository.Save(id, &proof); err != nil {
+		return "", fmt.Errorf("failed to save proof %s: %w", id, err)
+	}
+	return id, nil
+}
+
+func readArchiveEntry(reader io.Reader, header *tar.Header, value any) error {
+	if header.Size > maxArchiveEntrySize {
+		return fmt.Errorf("%s is too large (%d bytes)", header.Name, header.Size)
+	}
+	body, err := io.ReadAll(io.LimitReader(reader, header.Size))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", header.Name, err)
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		return fmt.Errorf("failed to json.Unmarshal %s: %w", header.Name, err)
+	}
+	return nil
+}
+
+// validProofId accepts only ids computed by computeId, a lowercase hex md5, so that an id never names a file
+// outside of the proof files.
+func validProofId(id string) bool {
+	if len(id) != md5.Size*2 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
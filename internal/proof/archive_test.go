@@ -0,0 +1,81 @@
+package proof
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestExportAndImportArchive(t *testing.T) {
+	source := newTestDiskRepository(t)
+	source.Save(testId("a"), &FileProof{BlockNumber: "0x1", FinalPair: []byte("pair"), Proof: []byte("proof-a")})
+	source.Save(testId("b"), &FileProof{BlockNumber: "0x2", Error: "failed"})
+	var archive bytes.Buffer
+	count, err := ExportArchive(&archive, source, []string{testId("a"), testId("b"), testId("missing")})
+	if err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("exported proof count mismatch. expected 2, but got %v", count)
+	}
+
+	target := newTestDiskRepository(t)
+	target.Save(testId("b"), &FileProof{BlockNumber: "0x2", Error: "failed"})
+	result, err := ImportArchive(bytes.NewReader(archive.Bytes()), target)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if *result != (ImportResult{Imported: 1, Duplicates: 1}) {
+		t.Errorf("import result mismatch. got %+v", result)
+	}
+	proof := target.Find(testId("a"))
+	if proof == nil || string(proof.Proof) != "proof-a" || proof.BlockNumber != "0x1" {
+		t.Errorf("imported proof mismatch. got %+v", proof)
+	}
+	if !proof.CreatedAt.Equal(source.Find(testId("a")).CreatedAt) {
+		t.Errorf("created time must be kept")
+	}
+}
+
+func TestImportArchiveRejectsTamperedProof(t *testing.T) {
+	source := newTestDiskRepository(t)
+	source.Save(testId("a"), &FileProof{Proof: []byte("proof-a")})
+	var archive bytes.Buffer
+	if _, err := ExportArchive(&archive, source, []string{testId("a")}); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	// Rewrite the archive with a modified proof.
+	gzipReader, _ := gzip.NewReader(&archive)
+	tarReader := tar.NewReader(gzipReader)
+	var tampered bytes.Buffer
+	gzipWriter := gzip.NewWriter(&tampered)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		body, _ := io.ReadAll(tarReader)
+		body = bytes.Replace(body, []byte("cHJvb2YtYQ=="), []byte("cHJvb2YtYg=="), 1)
+		header.Size = int64(len(body))
+		_ = tarWriter.WriteHeader(header)
+		_, _ = tarWriter.Write(body)
+	}
+	_ = tarWriter.Close()
+	_ = gzipWriter.Close()
+
+	target := newTestDiskRepository(t)
+	result, err := ImportArchive(&tampered, target)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if *result != (ImportResult{Invalid: 1}) {
+		t.Errorf("import result mismatch. got %+v", result)
+	}
+	if target.Find(testId("a")) != nil {
+		t.Errorf("tampered proof must not be imported")
+	}
+}
@@ -0,0 +1,176 @@
+package proof
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "halfOpen"
+)
+
+var (
+	breakerStateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "prover_proxy_circuit_breaker_state",
+		Help: "The state of the prover circuit breaker (0: closed, 1: half-open, 2: open).",
+	}, []string{"chain"})
+	breakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_proxy_circuit_breaker_rejections_total",
+		Help: "The number of proof requests rejected by the open prover circuit breaker.",
+	}, []string{"chain"})
+)
+
+// BreakerStatus is the state of the circuit breaker reported in /health.
+type BreakerStatus struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	LastError           string       `json:"lastError,omitempty"`
+	// RetryAt is the time the open breaker lets a probe job through.
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+}
+
+// circuitBreaker stops booting a broken prover. It opens after threshold consecutive non-deterministic failures,
+// and lets a single probe job through after cooldown. The probe closes it on success and opens it again on failure.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	chain     string
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	lastError string
+	openedAt  time.Time
+	// probe is the id of the job probing the prover in half-open state.
+	probe string
+}
+
+// newCircuitBreaker returns a breaker which never opens if threshold is not positive.
+func newCircuitBreaker(threshold int, cooldown time.Duration, chainId uint64) *circuitBreaker {
+	b := &circuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+	if chainId != 0 {
+		b.chain = strconv.FormatUint(chainId, 10)
+	}
+	breakerStateGauge.WithLabelValues(b.chain).Set(0)
+	return b
+}
+
+// check returns an error if a new job would be rejected at now.
+func (b *circuitBreaker) check(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rejection(now, "")
+}
+
+// allow returns an error if the job may not use the prover at now. In half-open state, the job becomes the probe
+// unless another job is probing.
+func (b *circuitBreaker) allow(id string, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.rejection(now, id); err != nil {
+		return err
+	}
+	if b.state == BreakerOpen {
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		b.probe = id
+	}
+	return nil
+}
+
+// rejection returns the error of a rejected job. b.mu must be held.
+func (b *circuitBreaker) rejection(now time.Time, id string) error {
+	switch b.state {
+	case BreakerOpen:
+		if retryAt := b.openedAt.Add(b.cooldown); now.Before(retryAt) {
+			breakerRejections.WithLabelValues(b.chain).Inc()
+			return &JsonRpcError{
+				Code:    -32000,
+				Message: fmt.Sprintf("prover circuit breaker is open after %d consecutive failures. last error: %s", b.failures, b.lastError),
+				Data:    b.status(),
+			}
+		}
+	case BreakerHalfOpen:
+		if len(b.probe) != 0 && b.probe != id {
+			breakerRejections.WithLabelValues(b.chain).Inc()
+			return &JsonRpcError{
+				Code:    -32000,
+				Message: fmt.Sprintf("prover circuit breaker is half-open and probing the prover. last error: %s", b.lastError),
+				Data:    b.status(),
+			}
+		}
+	}
+	return nil
+}
+
+// success records that the prover served the job.
+func (b *circuitBreaker) success(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.probe == id {
+		b.probe = ""
+	}
+	b.setState(BreakerClosed)
+}
+
+// failure records a failure of the job. Deterministic failures are caused by the trace and count as successes.
+func (b *circuitBreaker) failure(id string, err error, now time.Time) {
+	if isDeterministicFailure(err) {
+		b.success(id)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	if b.probe == id {
+		b.probe = ""
+	}
+	if b.threshold > 0 && (b.state == BreakerHalfOpen || b.failures >= b.threshold) {
+		b.openedAt = now
+		b.setState(BreakerOpen)
+	}
+}
+
+// setState changes the state. b.mu must be held.
+func (b *circuitBreaker) setState(state BreakerState) {
+	b.state = state
+	value := map[BreakerState]float64{BreakerClosed: 0, BreakerHalfOpen: 1, BreakerOpen: 2}[state]
+	breakerStateGauge.WithLabelValues(b.chain).Set(value)
+}
+
+func (b *circuitBreaker) Status() *BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status()
+}
+
+// status returns the state of the breaker. b.mu must be held.
+func (b *circuitBreaker) status() *BreakerStatus {
+	status := &BreakerStatus{State: b.state, ConsecutiveFailures: b.failures, LastError: b.lastError}
+	if b.state == BreakerOpen {
+		retryAt := b.openedAt.Add(b.cooldown)
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// isDeterministicFailure reports whether the prover rejected the request itself, which fails again on retry.
+func isDeterministicFailure(err error) bool {
+	var rpcError *JsonRpcError
+	if !errors.As(err, &rpcError) {
+		return false
+	}
+	return rpcError.Code == -32600 || rpcError.Code == -32602
+}
@@ -0,0 +1,51 @@
+package proof
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, time.Minute, 0)
+	now := time.Now()
+	unavailable := errors.New("connection refused")
+
+	b.failure("a", unavailable, now)
+	b.failure("b", NewInvalidParamsError("invalid trace", nil), now)
+	b.failure("c", unavailable, now)
+	if b.Status().State != BreakerClosed {
+		t.Fatalf("deterministic failure must reset the consecutive failures. got %+v", b.Status())
+	}
+	b.failure("d", unavailable, now)
+	if b.Status().State != BreakerOpen {
+		t.Fatalf("breaker must open after 2 consecutive failures. got %+v", b.Status())
+	}
+	if err := b.check(now.Add(30 * time.Second)); err == nil {
+		t.Errorf("open breaker must reject jobs")
+	}
+
+	later := now.Add(2 * time.Minute)
+	if err := b.allow("probe", later); err != nil {
+		t.Fatalf("breaker must let a probe through after the cooldown: %v", err)
+	}
+	if err := b.allow("other", later); err == nil {
+		t.Errorf("half-open breaker must let a single probe through")
+	}
+	b.failure("probe", unavailable, later)
+	if b.Status().State != BreakerOpen {
+		t.Fatalf("failed probe must open the breaker. got %+v", b.Status())
+	}
+
+	latest := later.Add(2 * time.Minute)
+	if err := b.allow("probe", latest); err != nil {
+		t.Fatalf("breaker must let a probe through after the cooldown: %v", err)
+	}
+	b.success("probe")
+	if status := b.Status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("successful probe must close the breaker. got %+v", status)
+	}
+	if err := b.allow("other", latest); err != nil {
+		t.Errorf("closed breaker must let jobs through: %v", err)
+	}
+}
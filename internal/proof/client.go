@@ -1,7 +1,9 @@
 package proof
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +13,8 @@ import (
 )
 
 type ProverClient interface {
-	Prove(traceString string) (*ProveResponse, error)
+	// Prove sends the trace read from trace without holding it in memory. Canceling ctx aborts the request.
+	Prove(ctx context.Context, trace io.Reader) (*ProveResponse, error)
 	Spec() (*ProverSpecResponse, error)
 }
 
@@ -47,6 +50,10 @@ func NewJsonRpcErrorFromString(err string) *JsonRpcError {
 	return &JsonRpcError{Code: -32000, Message: err}
 }
 
+func NewInvalidParamsError(err string, data any) *JsonRpcError {
+	return &JsonRpcError{Code: -32602, Message: err, Data: data}
+}
+
 func NewJsonRpcErrorFromErrorOrNil(err error) (rpcError *JsonRpcError) {
 	errors.As(err, &rpcError)
 	return
@@ -54,9 +61,50 @@ func NewJsonRpcErrorFromErrorOrNil(err error) (rpcError *JsonRpcError) {
 
 func (j *JsonRpcError) Error() string { return fmt.Sprintf("[%d] %s", j.Code, j.Message) }
 
-func (d dialJsonRpcProverClient) Prove(traceString string) (*ProveResponse, error) {
+func (d dialJsonRpcProverClient) Prove(ctx context.Context, trace io.Reader) (*ProveResponse, error) {
 	log.Println("send request to generate proof to prover")
-	return send[ProveResponse](d.address, "prove", []any{traceString})
+	body, writer := io.Pipe()
+	go func() {
+		// The transport closes body if the request fails, which stops the writes.
+		writer.CloseWithError(writeProveRequest(writer, trace))
+	}()
+	return post[ProveResponse](ctx, d.address, body)
+}
+
+// writeProveRequest writes the prove request of the trace, escaping the trace into the json string parameter.
+func writeProveRequest(writer io.Writer, trace io.Reader) error {
+	buffered := bufio.NewWriterSize(writer, 64<<10)
+	if _, err := buffered.WriteString(`{"jsonrpc":"2.0","method":"prove","params":["`); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(trace, 64<<10)
+	for {
+		c, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == '"' || c == '\\':
+			err = buffered.WriteByte('\\')
+			if err == nil {
+				err = buffered.WriteByte(c)
+			}
+		case c < 0x20:
+			_, err = fmt.Fprintf(buffered, `\u%04x`, c)
+		default:
+			err = buffered.WriteByte(c)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := buffered.WriteString(`"],"id":"0"}`); err != nil {
+		return err
+	}
+	return buffered.Flush()
 }
 
 func (d dialJsonRpcProverClient) Spec() (*ProverSpecResponse, error) {
@@ -70,11 +118,22 @@ func send[T any](address string, method string, params any) (*T, error) {
 	if err != nil {
 		log.Panicln(fmt.Errorf("failed to json.Marshal %w", err))
 	}
-	httpResponse, err := http.Post(address, "application/json", bytes.NewReader(jsonBytes))
+	return post[T](context.Background(), address, bytes.NewReader(jsonBytes))
+}
+
+// post sends the json rpc request read from body and decodes the response.
+func post[T any](ctx context.Context, address string, body io.Reader) (*T, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, address, body)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpResponse, err := http.DefaultClient.Do(httpRequest)
 	if err != nil {
 		return nil, err
 	}
-	jsonBytes, err = io.ReadAll(httpResponse.Body)
+	defer httpResponse.Body.Close()
+	jsonBytes, err := io.ReadAll(httpResponse.Body)
 	if err != nil {
 		return nil, err
 	}
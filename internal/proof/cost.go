@@ -0,0 +1,162 @@
+package proof
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kroma-network/kroma-prover-proxy/internal/ec2"
+)
+
+// CostReport is the aggregate cost since the proofs are stored. It is saved with the proofs, so that it survives
+// a restart, but the cost of an instance left running while the proxy is down is not counted.
+type CostReport struct {
+	Since          time.Time `json:"since"`
+	TotalCost      float64   `json:"totalCost"`
+	AttributedCost float64   `json:"attributedCost"`
+	IdleCost       float64   `json:"idleCost"`
+	// ProofCount is the number of successful jobs, and FailedJobCount the number of failed jobs.
+	ProofCount     int `json:"proofCount"`
+	FailedJobCount int `json:"failedJobCount"`
+	// AverageCostPerJob is the attributed cost divided by the number of finished jobs, failed jobs included,
+	// because the cost of a failed job is attributed as well.
+	AverageCostPerJob float64  `json:"averageCostPerJob"`
+	RunningInstances  []string `json:"runningInstances"`
+}
+
+type ProofCostReport struct {
+	Id   string  `json:"id"`
+	Cost float64 `json:"cost"`
+}
+
+// costAccountant attributes the cost of running instances to the jobs in progress.
+// Between two consecutive changes (an instance starts or stops, a job starts or finishes)
+// the cost of the running instances is split equally between the jobs in progress.
+// Cost accrued while no job is in progress is reported as idle.
+type costAccountant struct {
+	prices    map[string]float64
+	mu        sync.Mutex
+	instances map[string]string
+	jobs      map[string]float64
+	last      time.Time
+	report    CostReport
+	// save persists the report. It is nil if the report is kept in memory only.
+	save func(report *CostReport)
+}
+
+func newCostAccountant(prices map[string]float64) *costAccountant {
+	now := time.Now()
+	return &costAccountant{
+		prices:    prices,
+		instances: make(map[string]string),
+		jobs:      make(map[string]float64),
+		last:      now,
+		report:    CostReport{Since: now},
+	}
+}
+
+// persist restores the report saved in the repository, and saves the report there whenever a job finishes or an
+// instance stops.
+func (a *costAccountant) persist(repository Repository) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if report := repository.FindCostReport(); report != nil {
+		a.report = *report
+		a.report.RunningInstances = nil
+	}
+	a.save = repository.SaveCostReport
+}
+
+// flush saves the cost accrued since the last change.
+func (a *costAccountant) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accrue(time.Now())
+	a.saveReport()
+}
+
+// saveReport saves the report if it is persisted. a.mu must be held.
+func (a *costAccountant) saveReport() {
+	if a.save != nil {
+		report := a.report
+		a.save(&report)
+	}
+}
+
+func (a *costAccountant) onEvent(event ec2.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch event.Type {
+	case ec2.EventStart:
+		a.accrue(time.Now())
+		if _, ok := a.prices[event.InstanceType]; !ok {
+			log.Printf("hourly price of instance type %s is not configured", event.InstanceType)
+		}
+		a.instances[event.InstanceId] = event.InstanceType
+	case ec2.EventStop:
+		a.accrue(time.Now())
+		delete(a.instances, event.InstanceId)
+		a.saveReport()
+	}
+}
+
+func (a *costAccountant) startJob(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accrue(time.Now())
+	a.jobs[id] = 0
+}
+
+// finishJob returns the cost attributed to the job.
+func (a *costAccountant) finishJob(id string, proven bool) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accrue(time.Now())
+	cost := a.jobs[id]
+	delete(a.jobs, id)
+	if proven {
+		a.report.ProofCount++
+	} else {
+		a.report.FailedJobCount++
+	}
+	a.saveReport()
+	return cost
+}
+
+func (a *costAccountant) Report() CostReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accrue(time.Now())
+	report := a.report
+	if jobs := report.ProofCount + report.FailedJobCount; jobs != 0 {
+		report.AverageCostPerJob = report.AttributedCost / float64(jobs)
+	}
+	report.RunningInstances = make([]string, 0, len(a.instances))
+	for instanceId := range a.instances {
+		report.RunningInstances = append(report.RunningInstances, instanceId)
+	}
+	return report
+}
+
+// accrue adds the cost since the last change. a.mu must be held.
+func (a *costAccountant) accrue(now time.Time) {
+	hours := now.Sub(a.last).Hours()
+	a.last = now
+	var cost float64
+	for _, instanceType := range a.instances {
+		cost += a.prices[instanceType] * hours
+	}
+	if cost == 0 {
+		return
+	}
+	a.report.TotalCost += cost
+	if len(a.jobs) == 0 {
+		a.report.IdleCost += cost
+		return
+	}
+	a.report.AttributedCost += cost
+	share := cost / float64(len(a.jobs))
+	for id := range a.jobs {
+		a.jobs[id] += share
+	}
+}
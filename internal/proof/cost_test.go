@@ -0,0 +1,48 @@
+package proof
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCostAttribution(t *testing.T) {
+	accountant := newCostAccountant(map[string]float64{"g5.xlarge": 1.2})
+	start := time.Now()
+	accountant.last = start
+	accountant.instances["i-0"] = "g5.xlarge"
+	accountant.accrue(start.Add(1 * time.Hour))
+	accountant.jobs["a"], accountant.jobs["b"] = 0, 0
+	accountant.accrue(start.Add(2 * time.Hour))
+	delete(accountant.jobs, "b")
+	accountant.accrue(start.Add(3 * time.Hour))
+
+	assertCost(t, "idle", accountant.report.IdleCost, 1.2)
+	assertCost(t, "job a", accountant.jobs["a"], 1.8)
+	assertCost(t, "attributed", accountant.report.AttributedCost, 2.4)
+	assertCost(t, "total", accountant.report.TotalCost, 3.6)
+}
+
+func assertCost(t *testing.T, name string, actual, expected float64) {
+	if math.Abs(actual-expected) > 1e-9 {
+		t.Errorf("%s cost mismatch. expected %v, but got %v", name, expected, actual)
+	}
+}
+
+func TestCostReportPersisted(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	accountant := newCostAccountant(map[string]float64{"g5.xlarge": 1.2})
+	accountant.persist(disk)
+	accountant.report.AttributedCost = 3
+	accountant.finishJob("a", true)
+	accountant.finishJob("b", false)
+	accountant.finishJob("c", true)
+
+	restarted := newCostAccountant(nil)
+	restarted.persist(disk)
+	report := restarted.Report()
+	if report.ProofCount != 2 || report.FailedJobCount != 1 || !report.Since.Equal(accountant.report.Since) {
+		t.Errorf("report must be restored after a restart. got %+v", report)
+	}
+	assertCost(t, "average per job", report.AverageCostPerJob, 1)
+}
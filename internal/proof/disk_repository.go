@@ -2,26 +2,58 @@ package proof
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 type FileProof struct {
+	BlockNumber string    `json:"blockNumber,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Region is the region of the prover instance which generated the proof.
+	Region    string        `json:"region,omitempty"`
 	FinalPair []byte        `json:"final_pair,omitempty"`
 	Proof     []byte        `json:"proof,omitempty"`
 	Error     string        `json:"error,omitempty"`
 	RpcError  *JsonRpcError `json:"rpcError,omitempty"`
+	Cost      float64       `json:"cost,omitempty"`
+	// Checksum is the hex encoded sha256 of the payload. It is empty in proofs stored by older versions.
+	Checksum string `json:"checksum,omitempty"`
 }
 
+// computeChecksum hashes the length-prefixed payload fields so that bytes cannot move between fields.
+func (p *FileProof) computeChecksum() string {
+	hash := sha256.New()
+	for _, field := range [][]byte{p.FinalPair, p.Proof, []byte(p.Error)} {
+		_ = binary.Write(hash, binary.BigEndian, uint64(len(field)))
+		hash.Write(field)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// specFileName is the file storing the last spec of the prover. It is hidden from the proof files.
+const specFileName = ".spec"
+
+const costReportFileName = ".cost"
+
+// quarantineDir keeps corrupted proof files for investigation. It is hidden from the proof files.
+const quarantineDir = ".quarantine"
+
+// DiskRepository stores each proof in a file sharded by the first two characters of its id,
+// and keeps the metadata of the proofs in an index.
 type DiskRepository struct {
 	baseDir      string
-	deleteBefore time.Duration
+	index        *proofIndex
 	closeContext context.Context
-	Close        context.CancelFunc
+	cancel       context.CancelFunc
 }
 
 func NewDiskRepository(baseDir string) *DiskRepository {
@@ -34,21 +66,236 @@ func NewDiskRepository(baseDir string) *DiskRepository {
 	if !strings.HasSuffix(baseDir, "/") {
 		baseDir += "/"
 	}
+	index, err := openProofIndex(baseDir + indexFileName)
+	if err != nil {
+		log.Panicln(err)
+	}
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	disk := &DiskRepository{
 		baseDir:      baseDir,
-		deleteBefore: 7 * 24 * time.Hour,
+		index:        index,
 		closeContext: ctx,
-		Close:        cancelFunc,
+		cancel:       cancelFunc,
 	}
-	go disk.scheduleDeleteOldProof(10 * time.Minute)
+	disk.removeTempFiles()
+	disk.migrate()
 	return disk
 }
 
-func (r *DiskRepository) Find(id string) (proof *FileProof) {
-	file, err := os.ReadFile(r.baseDir + id)
+func (r *DiskRepository) Close() {
+	r.cancel()
+	if err := r.index.close(); err != nil {
+		log.Println(fmt.Errorf("failed to close proof index: %w", err))
+	}
+}
+
+var errInvalidProofId = errors.New("invalid proof id")
+
+// path returns the file of the proof. Ids are checked before they touch the file system,
+// because they are given by clients.
+func (r *DiskRepository) path(id string) (string, error) {
+	if !validProofId(id) {
+		return "", fmt.Errorf("%w %q", errInvalidProofId, id)
+	}
+	return filepath.Join(r.baseDir, id[:2], id), nil
+}
+
+// Find returns the stored proof. A corrupted proof is quarantined and reported as not found,
+// so that it is proved again.
+func (r *DiskRepository) Find(id string) *FileProof {
+	path, err := r.path(id)
+	if err != nil {
+		return nil
+	}
+	proof, err := readProof(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		r.quarantine(id, path, err)
+		return nil
+	}
+	return proof
+}
+
+// Meta returns the metadata of the stored proof without reading the proof file.
+func (r *DiskRepository) Meta(id string) *ProofMeta {
+	if !validProofId(id) {
+		return nil
+	}
+	meta, err := r.index.get(id)
+	if err != nil {
+		log.Println(fmt.Errorf("failed to read proof index: %w", err))
+	}
+	return meta
+}
+
+// List returns the metadata of the latest proofs, newest first. A non-positive limit lists all proofs.
+func (r *DiskRepository) List(limit int) []*ProofMeta {
+	result := make([]*ProofMeta, 0)
+	err := r.index.descend(func(meta *ProofMeta) bool {
+		result = append(result, meta)
+		return limit <= 0 || len(result) < limit
+	})
+	if err != nil {
+		log.Println(fmt.Errorf("failed to read proof index: %w", err))
+	}
+	return result
+}
+
+// ListByBlockRange returns the metadata of the proofs of the blocks from `from` to `to` inclusive in block order.
+func (r *DiskRepository) ListByBlockRange(from, to uint64, limit int) []*ProofMeta {
+	result := make([]*ProofMeta, 0)
+	err := r.index.blockRange(from, to, func(meta *ProofMeta) bool {
+		result = append(result, meta)
+		return limit <= 0 || len(result) < limit
+	})
+	if err != nil {
+		log.Println(fmt.Errorf("failed to read proof index: %w", err))
+	}
+	return result
+}
+
+func readProof(path string) (*FileProof, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var proof *FileProof
+	if err := json.Unmarshal(file, &proof); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal failed: %w", err)
+	}
+	if proof == nil {
+		return nil, errors.New("empty proof")
+	}
+	if len(proof.Checksum) != 0 && proof.Checksum != proof.computeChecksum() {
+		return nil, errors.New("checksum mismatch")
+	}
+	return proof, nil
+}
+
+// quarantine moves a corrupted proof file out of the proof files.
+func (r *DiskRepository) quarantine(id string, path string, cause error) {
+	if !validProofId(id) {
+		log.Println(fmt.Errorf("refuse to quarantine proof with %w %q", errInvalidProofId, id))
+		return
+	}
+	corruptedProofs.Inc()
+	log.Println(fmt.Errorf("proof %s is corrupted. quarantine it: %w", id, cause))
+	if err := r.index.delete(id); err != nil {
+		log.Println(fmt.Errorf("failed to delete %s from proof index: %w", id, err))
+	}
+	dir := filepath.Join(r.baseDir, quarantineDir)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Println(fmt.Errorf("os.MkdirAll failed: %w", err))
+		return
+	}
+	target := filepath.Join(dir, fmt.Sprintf("%s.%d", id, time.Now().UnixNano()))
+	if filepath.Dir(target) != filepath.Clean(dir) {
+		log.Println(fmt.Errorf("quarantine target %s is outside of %s", target, dir))
+		return
+	}
+	if err := os.Rename(path, target); err != nil {
+		log.Println(fmt.Errorf("failed to quarantine proof %s: %w", id, err))
+	}
+}
+
+// Save stores the proof atomically and indexes it. A crash leaves either the previous file or the complete new file.
+func (r *DiskRepository) Save(id string, proof *FileProof) error {
+	if proof.CreatedAt.IsZero() {
+		proof.CreatedAt = time.Now()
+	}
+	proof.Checksum = proof.computeChecksum()
+	jsonResult, err := json.Marshal(proof)
+	if err != nil {
+		return fmt.Errorf("json.Marshal failed: %w", err)
+	}
+	path, err := r.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return fmt.Errorf("os.MkdirAll failed: %w", err)
+	}
+	if err := writeFileAtomic(path, jsonResult); err != nil {
+		return err
+	}
+	return r.index.put(newProofMeta(id, proof, int64(len(jsonResult))))
+}
+
+func newProofMeta(id string, proof *FileProof, size int64) *ProofMeta {
+	return &ProofMeta{
+		Id:          id,
+		BlockNumber: proof.BlockNumber,
+		CreatedAt:   proof.CreatedAt,
+		Region:      proof.Region,
+		Size:        size,
+		Failed:      len(proof.Error) != 0,
+	}
+}
+
+// remove deletes the proof file and its metadata.
+func (r *DiskRepository) remove(id string) error {
+	path, err := r.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return r.index.delete(id)
+}
+
+// migrate moves the proof files stored in the base directory by older versions into the shards and indexes them.
+func (r *DiskRepository) migrate() {
+	files, _ := os.ReadDir(r.baseDir)
+	migrated := 0
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		id := file.Name()
+		path, err := r.path(id)
+		if err != nil {
+			log.Println(fmt.Errorf("skip migrating %s: %w", id, err))
+			continue
+		}
+		proof, err := readProof(r.baseDir + id)
+		if err != nil {
+			r.quarantine(id, r.baseDir+id, err)
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			log.Println(fmt.Errorf("failed to migrate proof %s: %w", id, err))
+			continue
+		}
+		if proof.CreatedAt.IsZero() {
+			proof.CreatedAt = info.ModTime()
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			log.Println(fmt.Errorf("os.MkdirAll failed: %w", err))
+			continue
+		}
+		if err := os.Rename(r.baseDir+id, path); err != nil {
+			log.Println(fmt.Errorf("failed to migrate proof %s: %w", id, err))
+			continue
+		}
+		if err := r.index.put(newProofMeta(id, proof, info.Size())); err != nil {
+			log.Println(fmt.Errorf("failed to index proof %s: %w", id, err))
+			continue
+		}
+		migrated++
+	}
+	if migrated != 0 {
+		log.Printf("migrated %d proofs into shards\n", migrated)
+	}
+}
+
+func (r *DiskRepository) FindSpec() (spec *SpecResponse) {
+	file, err := os.ReadFile(r.baseDir + specFileName)
 	if err == nil {
-		err = json.Unmarshal(file, &proof)
+		err = json.Unmarshal(file, &spec)
 		if err != nil {
 			log.Printf("json.Unmarshal failed. %v", err)
 		}
@@ -56,49 +303,94 @@ func (r *DiskRepository) Find(id string) (proof *FileProof) {
 	return
 }
 
-func (r *DiskRepository) Save(id string, proof *FileProof) {
-	jsonResult, _ := json.Marshal(proof)
-	err := os.WriteFile(r.baseDir+id, jsonResult, 0644)
+func (r *DiskRepository) SaveSpec(spec *SpecResponse) {
+	jsonResult, err := json.Marshal(spec)
+	if err == nil {
+		err = writeFileAtomic(r.baseDir+specFileName, jsonResult)
+	}
 	if err != nil {
-		log.Printf("os.WriteFile failed. %v", err)
+		log.Println(fmt.Errorf("failed to save spec: %w", err))
+	}
+}
 
+func (r *DiskRepository) FindCostReport() (report *CostReport) {
+	file, err := os.ReadFile(r.baseDir + costReportFileName)
+	if err == nil {
+		err = json.Unmarshal(file, &report)
+		if err != nil {
+			log.Printf("json.Unmarshal failed. %v", err)
+		}
 	}
 	return
 }
 
-func (r *DiskRepository) scheduleDeleteOldProof(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			deletedCount := r.deleteOldProof(time.Now().Add(-r.deleteBefore))
-			log.Printf("deleted old proof count %d\n", deletedCount)
-		case <-r.closeContext.Done():
-			return
-		}
+func (r *DiskRepository) SaveCostReport(report *CostReport) {
+	jsonResult, err := json.Marshal(report)
+	if err == nil {
+		err = writeFileAtomic(r.baseDir+costReportFileName, jsonResult)
+	}
+	if err != nil {
+		log.Println(fmt.Errorf("failed to save cost report: %w", err))
 	}
 }
 
-// deleteOldProof deletes proofs stored at a time earlier than time.
-func (r *DiskRepository) deleteOldProof(time time.Time) (deletedCount int) {
-	files, _ := os.ReadDir(r.baseDir)
-	for _, file := range files {
-		info, _ := file.Info()
-		hasError := func() bool {
-			proof := r.Find(file.Name())
-			if proof == nil {
-				return false
-			}
-			return len(proof.Error) != 0
+// tempFilePattern names temporary files as hidden files, so that they are never read as proofs.
+const tempFilePattern = ".*.tmp"
+
+// writeFileAtomic writes data to a temporary file in the same directory, syncs it and renames it to path.
+// The directory is synced as well so that the rename survives a crash.
+func writeFileAtomic(path string, data []byte) (err error) {
+	dir, name := filepath.Split(path)
+	file, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp failed: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
 		}
-		if info.ModTime().Before(time) || hasError() {
-			if err := os.Remove(r.baseDir + file.Name()); err != nil {
-				log.Println(fmt.Errorf("failed to delete old proof %s: %w", file.Name(), err))
-			} else {
-				deletedCount++
-			}
+	}()
+	if _, err = file.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err = file.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to chmod %s: %w", path, err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", path, err)
+	}
+	if err = os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", path, err)
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	if len(dir) == 0 {
+		dir = "."
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dir, err)
+	}
+	return nil
+}
+
+// removeTempFiles removes temporary files left by a crash during writeFileAtomic.
+func (r *DiskRepository) removeTempFiles() {
+	files, _ := filepath.Glob(filepath.Join(r.baseDir, tempFilePattern))
+	shardFiles, _ := filepath.Glob(filepath.Join(r.baseDir, "*", tempFilePattern))
+	for _, file := range append(files, shardFiles...) {
+		if err := os.Remove(file); err != nil {
+			log.Println(fmt.Errorf("failed to remove temporary file %s: %w", file, err))
 		}
 	}
-	return
 }
@@ -2,8 +2,13 @@ package proof
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -11,7 +16,7 @@ import (
 func TestDiskSaveAndFind(t *testing.T) {
 	disk := newTestDiskRepository(t)
 	input, _ := disk.saveTestProof(1)
-	result := disk.Find("0")
+	result := disk.Find(testId("0"))
 	if result == nil {
 		t.Errorf("proof not exist")
 	}
@@ -23,32 +28,140 @@ func TestDiskSaveAndFind(t *testing.T) {
 	}
 }
 
-func TestDeleteOldProof(t *testing.T) {
+func TestSaveLeavesNoTempFile(t *testing.T) {
 	disk := newTestDiskRepository(t)
-	now := time.Now()
-	proofs, sleepPerProof := disk.saveTestProof(10)
-	disk.deleteOldProof(now.Add(time.Duration(len(proofs)/2) * sleepPerProof))
-	files, _ := os.ReadDir(disk.baseDir)
-	if len(files) != len(proofs)/2 {
-		t.Errorf("saved proof count mismatch. expected %v, but got %v", len(proofs)/2, len(files))
+	_ = os.WriteFile(disk.baseDir+".crashed.123.tmp", []byte(`{"final_pair":`), 0644)
+	disk.removeTempFiles()
+	if err := disk.Save(testId("0"), &FileProof{Proof: []byte("proof")}); err != nil {
+		t.Fatalf("failed to save proof: %v", err)
+	}
+	files, _ := os.ReadDir(filepath.Dir(disk.testPath("0")))
+	if len(files) != 1 || files[0].Name() != testId("0") {
+		t.Errorf("only the proof file must exist. got %v", files)
+	}
+}
+
+func TestFindCorruptedProof(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	disk.Save(testId("tampered"), &FileProof{FinalPair: []byte("pair"), Proof: []byte("proof")})
+	file, _ := os.ReadFile(disk.testPath("tampered"))
+	_ = os.WriteFile(disk.testPath("tampered"), bytes.Replace(file, []byte("cHJvb2Y="), []byte("cHJvb2g="), 1), 0644)
+	_ = os.MkdirAll(filepath.Dir(disk.testPath("truncated")), 0777)
+	_ = os.MkdirAll(filepath.Dir(disk.testPath("legacy")), 0777)
+	_ = os.WriteFile(disk.testPath("truncated"), []byte(`{"final_pair":"cGFp`), 0644)
+	_ = os.WriteFile(disk.testPath("legacy"), []byte(`{"final_pair":"cGFpcg==","proof":"cHJvb2Y="}`), 0644)
+
+	for _, name := range []string{"tampered", "truncated"} {
+		if disk.Find(testId(name)) != nil {
+			t.Errorf("corrupted proof %s must not be found", name)
+		}
+		if _, err := os.Stat(disk.testPath(name)); !os.IsNotExist(err) {
+			t.Errorf("corrupted proof %s must be quarantined", name)
+		}
+	}
+	if disk.Find(testId("legacy")) == nil {
+		t.Errorf("proof without checksum must be found")
+	}
+	if disk.Meta(testId("tampered")) != nil {
+		t.Errorf("quarantined proof must be removed from the index")
+	}
+	quarantined, _ := os.ReadDir(disk.baseDir + quarantineDir)
+	if len(quarantined) != 2 {
+		t.Errorf("quarantined proof count mismatch. expected 2, but got %v", len(quarantined))
 	}
 }
 
+func TestMigrateLegacyProof(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	disk.Close()
+	_ = os.WriteFile(disk.baseDir+testId("legacy"), []byte(`{"final_pair":"cGFpcg==","proof":"cHJvb2Y="}`), 0644)
+	_ = os.WriteFile(disk.baseDir+testId("failed"), []byte(`{"error":"out of memory"}`), 0644)
+	_ = os.WriteFile(disk.baseDir+"notes", []byte(`{}`), 0644)
+
+	disk = NewDiskRepository(disk.baseDir)
+	t.Cleanup(disk.Close)
+	if _, err := os.Stat(disk.baseDir + testId("legacy")); !os.IsNotExist(err) {
+		t.Errorf("legacy proof must be moved into its shard")
+	}
+	if _, err := os.Stat(disk.baseDir + "notes"); err != nil {
+		t.Errorf("file which is not a proof must be left alone. got %v", err)
+	}
+	if proof := disk.Find(testId("legacy")); proof == nil || string(proof.Proof) != "proof" {
+		t.Errorf("migrated proof mismatch. got %+v", proof)
+	}
+	if meta := disk.Meta(testId("failed")); meta == nil || !meta.Failed {
+		t.Errorf("failed proof must be indexed as failed. got %+v", meta)
+	}
+	if listed := disk.List(10); len(listed) != 2 {
+		t.Errorf("listed proof count mismatch. expected 2, but got %v", len(listed))
+	}
+}
+
+func TestRejectInvalidProofId(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	_ = os.WriteFile(outside, []byte(`{"final_pair":"cGFp`), 0644)
+	disk := NewDiskRepository(filepath.Join(dir, "proofs"))
+	t.Cleanup(disk.Close)
+
+	for _, id := range []string{"../outside", "../../" + testId("0"), strings.ToUpper(computeId("0")), testId("0")[1:], ""} {
+		if disk.Find(id) != nil || disk.Meta(id) != nil {
+			t.Errorf("proof with invalid id %q must not be found", id)
+		}
+		if err := disk.Save(id, &FileProof{Proof: []byte("proof")}); !errors.Is(err, errInvalidProofId) {
+			t.Errorf("proof with invalid id %q must not be saved. got %v", id, err)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside of the base dir must not be moved. got %v", err)
+	}
+}
+
+func TestDiskSaveAndFindSpec(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	if disk.FindSpec() != nil {
+		t.Errorf("spec must not exist")
+	}
+	disk.SaveSpec(&SpecResponse{ProverSpecResponse: ProverSpecResponse{Degree: 25, ChainId: 255}, FetchedAt: time.Now()})
+	disk.applyRetention(time.Now().Add(time.Hour), RetentionConfig{Success: RetentionPolicy{MaxAge: time.Minute}, Error: RetentionPolicy{MaxCount: 1}})
+	spec := disk.FindSpec()
+	if spec == nil {
+		t.Fatalf("spec not exist")
+	}
+	if spec.Degree != 25 || spec.ChainId != 255 {
+		t.Errorf("spec mismatch. got %+v", spec)
+	}
+}
+
+// testId returns a valid proof id encoding name. Ids of names of the same length sort like the names.
+func testId(name string) string {
+	id := hex.EncodeToString([]byte(name))
+	return strings.Repeat("0", md5.Size*2-len(id)) + id
+}
+
+// testPath returns the path of the proof file of testId(name).
+func (r *DiskRepository) testPath(name string) string {
+	path, _ := r.path(testId(name))
+	return path
+}
+
 func newTestDiskRepository(t *testing.T) *DiskRepository {
-	disk := NewDiskRepository("./" + t.Name())
-	t.Cleanup(func() { os.RemoveAll(disk.baseDir) })
+	disk := NewDiskRepository(t.TempDir())
+	t.Cleanup(disk.Close)
 	return disk
 }
 
-func (r *DiskRepository) saveTestProof(count int) (result []*FileProof, sleepPerProof time.Duration) {
-	sleepPerProof = 1 * time.Second
+// saveTestProof saves count proofs created stepPerProof apart, starting at now.
+func (r *DiskRepository) saveTestProof(count int) (result []*FileProof, stepPerProof time.Duration) {
+	stepPerProof = 1 * time.Second
+	now := time.Now()
 	for i := 0; i < count; i++ {
 		result = append(result, &FileProof{
+			CreatedAt: now.Add(time.Duration(i) * stepPerProof),
 			FinalPair: []byte("test-" + strconv.Itoa(i)),
 			Proof:     []byte("test-" + strconv.Itoa(i)),
 		})
-		r.Save(strconv.Itoa(i), result[len(result)-1])
-		time.Sleep(sleepPerProof)
+		r.Save(testId(strconv.Itoa(i)), result[len(result)-1])
 	}
 	return
 }
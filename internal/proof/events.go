@@ -0,0 +1,90 @@
+package proof
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	TopicJobEvents      = "jobEvents"
+	TopicInstanceEvents = "instanceEvents"
+
+	subscriptionBufferSize = 64
+)
+
+type JobEvent struct {
+	ChainId     uint64    `json:"chainId,omitempty"`
+	Id          string    `json:"id"`
+	BlockNumber string    `json:"blockNumber,omitempty"`
+	State       JobState  `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// listener is called with each event of its topic.
+type listener struct {
+	topic string
+	fn    func(event any)
+}
+
+type subscription struct {
+	topic  string
+	events chan any
+}
+
+// eventFeed delivers job and instance events to subscriptions and listeners.
+// Events are dropped for a subscription that does not keep up, but never for a listener.
+type eventFeed struct {
+	mu            sync.Mutex
+	subscriptions map[*subscription]struct{}
+	listeners     []listener
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{subscriptions: make(map[*subscription]struct{})}
+}
+
+func (f *eventFeed) subscribe(topic string) *subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub := &subscription{topic: topic, events: make(chan any, subscriptionBufferSize)}
+	f.subscriptions[sub] = struct{}{}
+	return sub
+}
+
+// listen calls fn with each event of topic while the event is published. fn must not block.
+func (f *eventFeed) listen(topic string, fn func(event any)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listeners = append(f.listeners, listener{topic: topic, fn: fn})
+}
+
+func (f *eventFeed) unsubscribe(sub *subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subscriptions[sub]; ok {
+		delete(f.subscriptions, sub)
+		close(sub.events)
+	}
+}
+
+func (f *eventFeed) publish(topic string, event any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, l := range f.listeners {
+		if l.topic == topic {
+			l.fn(event)
+		}
+	}
+	for sub := range f.subscriptions {
+		if sub.topic != topic {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("subscription of %s is full. drop event", topic)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package proof
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeFailoverController serves the prover of addresses[active] in regions[active].
+type fakeFailoverController struct {
+	regions   []string
+	addresses []string
+	active    int
+	failovers int
+}
+
+func (c *fakeFailoverController) StartIfNotRunning() error    { return nil }
+func (c *fakeFailoverController) StopIfRunning()              {}
+func (c *fakeFailoverController) IpAddress() string           { return c.addresses[c.active] }
+func (c *fakeFailoverController) Running() bool               { return true }
+func (c *fakeFailoverController) Region() string              { return c.regions[c.active] }
+func (c *fakeFailoverController) ReadyTimeout() time.Duration { return 1500 * time.Millisecond }
+func (c *fakeFailoverController) Active() int                 { return c.active }
+func (c *fakeFailoverController) Failover(from int) bool {
+	c.failovers++
+	if from != c.active {
+		return true
+	}
+	if c.active+1 >= len(c.addresses) {
+		return false
+	}
+	c.active++
+	return true
+}
+
+func TestReadyClientFailsOverWhenServerIsNotReady(t *testing.T) {
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	prover := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(map[string]any{"jsonrpc": "2.0", "id": "0", "result": ProverSpecResponse{Degree: 25}})
+	}))
+	defer prover.Close()
+	controller := &fakeFailoverController{
+		regions:   []string{"ap-northeast-2", "us-east-1"},
+		addresses: []string{unreachable.URL, prover.URL},
+	}
+	s := &Service{disk: newTestDiskRepository(t), ec2: controller, events: newEventFeed()}
+
+	_, release, err := s.readyClient()
+	if err != nil {
+		t.Fatalf("failed to get ready client: %v", err)
+	}
+	release()
+	if controller.failovers != 1 || s.region() != "us-east-1" {
+		t.Errorf("must fail over to us-east-1. failovers %d, region %s", controller.failovers, s.region())
+	}
+
+	controller.addresses[1] = unreachable.URL
+	if _, _, err := s.readyClient(); err == nil {
+		t.Errorf("must fail if the server is not ready in the last region")
+	}
+}
+
+func TestReadyClientRejectsProverOfAnotherChain(t *testing.T) {
+	prover := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(map[string]any{"jsonrpc": "2.0", "id": "0", "result": ProverSpecResponse{Degree: 25, ChainId: 255}})
+	}))
+	defer prover.Close()
+	controller := &fakeFailoverController{regions: []string{"us-east-1"}, addresses: []string{prover.URL}}
+	s := &Service{chainId: 2358, disk: newTestDiskRepository(t), ec2: controller, events: newEventFeed()}
+
+	if _, _, err := s.readyClient(); err == nil {
+		t.Errorf("prover of chain 255 must not serve chain 2358")
+	}
+	if spec := s.disk.FindSpec(); spec != nil {
+		t.Errorf("spec of a prover of another chain must not be saved. got %+v", spec)
+	}
+}
@@ -0,0 +1,234 @@
+package proof
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// indexFileName is the file of the proof metadata index. It is hidden from the proof files.
+const indexFileName = ".index.db"
+
+var (
+	// proofsBucket maps a proof id to its ProofMeta.
+	proofsBucket = []byte("proofs")
+	// createdBucket has createdAt + id as keys, ordering the proofs by creation.
+	createdBucket = []byte("created")
+	// blocksBucket has blockNumber + id as keys, ordering the proofs by block number.
+	blocksBucket = []byte("blocks")
+)
+
+// ProofMeta is the metadata of a stored proof.
+type ProofMeta struct {
+	Id          string    `json:"id"`
+	BlockNumber string    `json:"blockNumber,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Region      string    `json:"region,omitempty"`
+	Size        int64     `json:"size"`
+	Failed      bool      `json:"failed"`
+}
+
+// proofIndex keeps the metadata of stored proofs so that they are listed and deleted without reading the proof files.
+type proofIndex struct {
+	db *bolt.DB
+}
+
+func openProofIndex(path string) (*proofIndex, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open proof index %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{proofsBucket, createdBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(blocksBucket) == nil {
+			return createBlocksBucket(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create proof index buckets: %w", err)
+	}
+	return &proofIndex{db: db}, nil
+}
+
+// createBlocksBucket creates the block number index of the proofs indexed by older versions.
+func createBlocksBucket(tx *bolt.Tx) error {
+	blocks, err := tx.CreateBucket(blocksBucket)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(proofsBucket).ForEach(func(k, v []byte) error {
+		var meta ProofMeta
+		if err := json.Unmarshal(v, &meta); err != nil {
+			return fmt.Errorf("failed to json.Unmarshal metadata of %s: %w", k, err)
+		}
+		if key, ok := blockKey(&meta); ok {
+			return blocks.Put(key, []byte{})
+		}
+		return nil
+	})
+}
+
+func (i *proofIndex) close() error {
+	return i.db.Close()
+}
+
+// put adds or replaces the metadata of a proof.
+func (i *proofIndex) put(meta *ProofMeta) error {
+	value, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("json.Marshal failed: %w", err)
+	}
+	return i.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteMeta(tx, meta.Id); err != nil {
+			return err
+		}
+		if err := tx.Bucket(proofsBucket).Put([]byte(meta.Id), value); err != nil {
+			return err
+		}
+		if err := tx.Bucket(createdBucket).Put(createdKey(meta), []byte{}); err != nil {
+			return err
+		}
+		if key, ok := blockKey(meta); ok {
+			return tx.Bucket(blocksBucket).Put(key, []byte{})
+		}
+		return nil
+	})
+}
+
+func (i *proofIndex) get(id string) (*ProofMeta, error) {
+	var meta *ProofMeta
+	err := i.db.View(func(tx *bolt.Tx) error {
+		var err error
+		meta, err = getMeta(tx, id)
+		return err
+	})
+	return meta, err
+}
+
+func (i *proofIndex) delete(id string) error {
+	return i.db.Update(func(tx *bolt.Tx) error { return deleteMeta(tx, id) })
+}
+
+// ascend calls fn with the proofs from the oldest until fn returns false.
+func (i *proofIndex) ascend(fn func(meta *ProofMeta) bool) error {
+	return i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(createdBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			meta, err := getMeta(tx, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			if meta != nil && !fn(meta) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// descend calls fn with the proofs from the newest until fn returns false.
+func (i *proofIndex) descend(fn func(meta *ProofMeta) bool) error {
+	return i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(createdBucket).Cursor()
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			meta, err := getMeta(tx, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			if meta != nil && !fn(meta) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// blockRange calls fn with the proofs of the blocks from `from` to `to` inclusive in block order until fn returns false.
+func (i *proofIndex) blockRange(from, to uint64, fn func(meta *ProofMeta) bool) error {
+	return i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(blocksBucket).Cursor()
+		start := binary.BigEndian.AppendUint64(nil, from)
+		for k, _ := c.Seek(start); k != nil && binary.BigEndian.Uint64(k) <= to; k, _ = c.Next() {
+			meta, err := getMeta(tx, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			if meta != nil && !fn(meta) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func getMeta(tx *bolt.Tx, id string) (*ProofMeta, error) {
+	value := tx.Bucket(proofsBucket).Get([]byte(id))
+	if value == nil {
+		return nil, nil
+	}
+	var meta ProofMeta
+	if err := json.Unmarshal(value, &meta); err != nil {
+		return nil, fmt.Errorf("failed to json.Unmarshal metadata of %s: %w", id, err)
+	}
+	return &meta, nil
+}
+
+func deleteMeta(tx *bolt.Tx, id string) error {
+	meta, err := getMeta(tx, id)
+	if err != nil || meta == nil {
+		return err
+	}
+	if err := tx.Bucket(createdBucket).Delete(createdKey(meta)); err != nil {
+		return err
+	}
+	if key, ok := blockKey(meta); ok {
+		if err := tx.Bucket(blocksBucket).Delete(key); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(proofsBucket).Delete([]byte(id))
+}
+
+// createdKey orders the proofs by creation time, then by id.
+func createdKey(meta *ProofMeta) []byte {
+	key := make([]byte, 8, 8+len(meta.Id))
+	binary.BigEndian.PutUint64(key, uint64(meta.CreatedAt.UnixNano()))
+	return append(key, meta.Id...)
+}
+
+// blockKey orders the proofs by block number, then by id. Proofs without a valid block number are not indexed.
+func blockKey(meta *ProofMeta) ([]byte, bool) {
+	number, err := ParseBlockNumber(meta.BlockNumber)
+	if err != nil {
+		return nil, false
+	}
+	key := make([]byte, 8, 8+len(meta.Id))
+	binary.BigEndian.PutUint64(key, number)
+	return append(key, meta.Id...), true
+}
+
+// ParseBlockNumber parses a block number given in hex with the 0x prefix or in decimal.
+func ParseBlockNumber(value string) (uint64, error) {
+	var number uint64
+	var err error
+	if hex, ok := strings.CutPrefix(value, "0x"); ok {
+		number, err = strconv.ParseUint(hex, 16, 64)
+	} else {
+		number, err = strconv.ParseUint(value, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid block number %s", value)
+	}
+	return number, nil
+}
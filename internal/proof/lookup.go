@@ -0,0 +1,36 @@
+package proof
+
+// maxProofsPerBlock limits the proofs returned for a block. A block has several proofs only if it is traced differently.
+const maxProofsPerBlock = 16
+
+// StoredProof is a stored proof with its metadata.
+type StoredProof struct {
+	*ProofMeta
+	Proof *ProveResponse `json:"proof,omitempty"`
+	Error *JsonRpcError  `json:"error,omitempty"`
+}
+
+// ProofsByBlockNumber returns the stored proofs of the block.
+func (s *Service) ProofsByBlockNumber(blockNumber uint64) []*StoredProof {
+	result := make([]*StoredProof, 0)
+	for _, meta := range s.disk.ListByBlockRange(blockNumber, blockNumber, maxProofsPerBlock) {
+		proof := s.disk.Find(meta.Id)
+		if proof == nil {
+			continue
+		}
+		stored := &StoredProof{ProofMeta: meta}
+		response, err := newProofResponseFromFileProof(proof)
+		if err != nil {
+			stored.Error = NewJsonRpcErrorFromErrorOrNil(err)
+		}
+		stored.Proof = response
+		result = append(result, stored)
+	}
+	return result
+}
+
+// ListByBlockRange returns the metadata of the stored proofs of the blocks from `from` to `to` inclusive.
+// The proofs are read with proof_getByBlockNumber.
+func (s *Service) ListByBlockRange(from, to uint64, limit int) []*ProofMeta {
+	return s.disk.ListByBlockRange(from, to, limit)
+}
@@ -0,0 +1,42 @@
+package proof
+
+import (
+	"testing"
+)
+
+func TestProofsByBlockNumber(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	disk.Save(testId("a"), &FileProof{BlockNumber: "0x1", Proof: []byte("proof-a")})
+	disk.Save(testId("b"), &FileProof{BlockNumber: "0x2", Proof: []byte("proof-b")})
+	disk.Save(testId("c"), &FileProof{BlockNumber: "0x2", Error: "failed"})
+	disk.Save(testId("d"), &FileProof{BlockNumber: "3", Proof: []byte("proof-d")})
+	disk.Save(testId("e"), &FileProof{BlockNumber: "0x10", Proof: []byte("proof-e")})
+	service := &Service{disk: disk}
+
+	proofs := service.ProofsByBlockNumber(2)
+	if len(proofs) != 2 {
+		t.Fatalf("proof count of block 2 mismatch. expected 2, but got %v", len(proofs))
+	}
+	if proofs[0].Id != testId("b") || string(proofs[0].Proof.Proof) != "proof-b" {
+		t.Errorf("proof b mismatch. got %+v", proofs[0])
+	}
+	if proofs[1].Id != testId("c") || proofs[1].Error == nil || proofs[1].Proof != nil {
+		t.Errorf("proof c must be an error. got %+v", proofs[1])
+	}
+
+	var ids []string
+	for _, meta := range service.ListByBlockRange(2, 16, 10) {
+		ids = append(ids, meta.Id)
+	}
+	if len(ids) != 4 || ids[0] != testId("b") || ids[1] != testId("c") || ids[2] != testId("d") || ids[3] != testId("e") {
+		t.Errorf("proofs of blocks 2-16 mismatch. got %v", ids)
+	}
+	if listed := service.ListByBlockRange(1, 3, 2); len(listed) != 2 {
+		t.Errorf("listed proof count must be limited. got %v", len(listed))
+	}
+
+	disk.remove(testId("b"))
+	if proofs := service.ProofsByBlockNumber(2); len(proofs) != 1 {
+		t.Errorf("deleted proof must be removed from the block index. got %v proofs", len(proofs))
+	}
+}
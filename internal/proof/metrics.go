@@ -0,0 +1,11 @@
+package proof
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var corruptedProofs = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "prover_proxy_corrupted_proofs_total",
+	Help: "The number of stored proofs quarantined because they are unreadable or fail the checksum.",
+})
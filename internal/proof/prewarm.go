@@ -0,0 +1,96 @@
+package proof
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// PrewarmWindow keeps the prover instance running for Duration after each activation of Schedule.
+type PrewarmWindow struct {
+	Schedule cron.Schedule
+	Duration time.Duration
+}
+
+// ParsePrewarmWindow parses a window in the form of "<cron spec>;<duration>", e.g. "0 9 * * 1-5;2h".
+func ParsePrewarmWindow(value string) (PrewarmWindow, error) {
+	spec, duration, ok := strings.Cut(value, ";")
+	if !ok {
+		return PrewarmWindow{}, fmt.Errorf("invalid pre-warm window %s: duration is missing", value)
+	}
+	schedule, err := cron.ParseStandard(strings.TrimSpace(spec))
+	if err != nil {
+		return PrewarmWindow{}, fmt.Errorf("invalid pre-warm schedule %s: %w", spec, err)
+	}
+	parsed, err := time.ParseDuration(strings.TrimSpace(duration))
+	if err != nil || parsed <= 0 {
+		return PrewarmWindow{}, fmt.Errorf("invalid pre-warm duration %s", duration)
+	}
+	return PrewarmWindow{Schedule: schedule, Duration: parsed}, nil
+}
+
+// activeSince returns the start of the window containing now.
+func (w PrewarmWindow) activeSince(now time.Time) (time.Time, bool) {
+	start := w.Schedule.Next(now.Add(-w.Duration))
+	return start, !start.After(now)
+}
+
+func (s *Service) inPrewarmWindow(now time.Time) bool {
+	for _, window := range s.prewarm {
+		if _, ok := window.activeSince(now); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// nextPrewarmChange returns the next time a window starts or ends.
+func (s *Service) nextPrewarmChange(now time.Time) time.Time {
+	var next time.Time
+	for _, window := range s.prewarm {
+		candidate := window.Schedule.Next(now)
+		if start, ok := window.activeSince(now); ok && start.Add(window.Duration).Before(candidate) {
+			candidate = start.Add(window.Duration)
+		}
+		if next.IsZero() || candidate.Before(next) {
+			next = candidate
+		}
+	}
+	return next
+}
+
+func (s *Service) schedulePrewarm() {
+	for {
+		next := s.nextPrewarmChange(time.Now())
+		if next.IsZero() {
+			log.Println("pre-warm schedules never activate")
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			if s.inPrewarmWindow(time.Now()) {
+				s.warmUp()
+			} else {
+				s.stopIfIdle()
+			}
+		case <-s.closeContext.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// warmUp starts the instance and waits for the prover server to be ready.
+func (s *Service) warmUp() {
+	log.Println("pre-warm window started. start prover instance.")
+	_, err := withClient(s, func(c ProverClient) (*struct{}, error) { return nil, nil })
+	if err != nil {
+		log.Println(fmt.Errorf("failed to pre-warm prover instance: %w", err))
+		return
+	}
+	log.Println("prover instance is pre-warmed.")
+}
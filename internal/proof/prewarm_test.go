@@ -0,0 +1,35 @@
+package proof
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrewarmWindow(t *testing.T) {
+	window, err := ParsePrewarmWindow("0 9 * * *;2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &Service{prewarm: []PrewarmWindow{window}}
+	day := time.Date(2023, 7, 1, 0, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		hour   time.Duration
+		active bool
+		next   time.Duration
+	}{
+		{8 * time.Hour, false, 9 * time.Hour},
+		{10 * time.Hour, true, 11 * time.Hour},
+		{12 * time.Hour, false, 33 * time.Hour},
+	} {
+		now := day.Add(tc.hour)
+		if active := service.inPrewarmWindow(now); active != tc.active {
+			t.Errorf("active mismatch at %v. expected %v, but got %v", now, tc.active, active)
+		}
+		if next := service.nextPrewarmChange(now); !next.Equal(day.Add(tc.next)) {
+			t.Errorf("next change mismatch at %v. expected %v, but got %v", now, day.Add(tc.next), next)
+		}
+	}
+	if _, err := ParsePrewarmWindow("0 9 * * *"); err == nil {
+		t.Errorf("window without duration must be rejected")
+	}
+}
@@ -0,0 +1,32 @@
+package proof
+
+// ProxyClient calls the json rpc methods of a running proxy.
+type ProxyClient struct {
+	address string
+}
+
+func NewProxyClient(address string) *ProxyClient {
+	return &ProxyClient{address: address}
+}
+
+// Prove waits until the proof is generated.
+func (c *ProxyClient) Prove(traceString string) (*ProveResponse, error) {
+	return send[ProveResponse](c.address, "prove", []any{traceString})
+}
+
+// Submit queues the proof generation and returns the status of the job.
+func (c *ProxyClient) Submit(traceString string) (*JobStatus, error) {
+	return send[JobStatus](c.address, "prove_submit", []any{traceString})
+}
+
+func (c *ProxyClient) Status(id string) (*JobStatus, error) {
+	return send[JobStatus](c.address, "proof_status", []any{id})
+}
+
+func (c *ProxyClient) Result(id string) (*ProveResponse, error) {
+	return send[ProveResponse](c.address, "proof_result", []any{id})
+}
+
+func (c *ProxyClient) Spec(refresh bool) (*SpecResponse, error) {
+	return send[SpecResponse](c.address, "spec", []any{refresh})
+}
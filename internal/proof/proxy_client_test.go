@@ -0,0 +1,32 @@
+package proof
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyClientResult(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	disk.Save(testId("a"), &FileProof{BlockNumber: "0x1", FinalPair: []byte("pair"), Proof: []byte("proof")})
+	httpServer := httptest.NewServer(NewServer(&Service{disk: disk, events: newEventFeed()}))
+	defer httpServer.Close()
+	client := NewProxyClient(httpServer.URL)
+
+	status, err := client.Status(testId("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != JobDone || status.BlockNumber != "0x1" {
+		t.Errorf("status mismatch. got %+v", status)
+	}
+	result, err := client.Result(testId("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.FinalPair) != "pair" || string(result.Proof) != "proof" {
+		t.Errorf("result mismatch. got %+v", result)
+	}
+	if _, err := client.Result(testId("missing")); err == nil {
+		t.Errorf("result of a missing proof must fail")
+	}
+}
@@ -0,0 +1,304 @@
+package proof
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitErrorCode = -32005
+	rateLimitWindow    = time.Minute
+	// concurrencyRetryAfter is the retry hint of a client at its job limit. Proofs take minutes, so any value is a guess.
+	concurrencyRetryAfter = 30 * time.Second
+	// idleClientTimeout is the time after which an idle client without jobs is forgotten.
+	idleClientTimeout = time.Hour
+	// maxTrackedClients bounds the memory of the clients.
+	maxTrackedClients = 10000
+)
+
+// ClientLimits are the limits of each client on the proving methods prove and prove_submit.
+// A client is identified by its X-Api-Key header if the key is one of ApiKeys, or by its IP address otherwise,
+// so that a client cannot escape its limits with made up keys. Zero disables a limit.
+type ClientLimits struct {
+	RequestsPerMinute int
+	MaxConcurrentJobs int
+	ApiKeys           []string
+}
+
+// ClientUsage is the usage of a client reported by admin_clientUsage.
+type ClientUsage struct {
+	// Client is "key:" followed by a hash of the api key, or "ip:" followed by the address.
+	Client            string    `json:"client"`
+	Requests          int       `json:"requests"`
+	Rejected          int       `json:"rejected"`
+	RequestsPerMinute int       `json:"requestsPerMinute"`
+	ActiveJobs        int       `json:"activeJobs"`
+	LastRequestAt     time.Time `json:"lastRequestAt"`
+}
+
+type clientState struct {
+	requests int
+	rejected int
+	// recent are the times of the requests in the last rateLimitWindow in order.
+	recent []time.Time
+	// calls is the number of running prove calls, which wait for their job.
+	calls int
+	// jobs are the ids of the jobs submitted with prove_submit. Completed jobs are removed by jobEvent.
+	jobs        map[string]struct{}
+	lastRequest time.Time
+}
+
+// clientLimiter enforces ClientLimits and records the usage of each client.
+type clientLimiter struct {
+	limits ClientLimits
+	// apiKeys are the clients of the configured api keys.
+	apiKeys map[string]string
+	// maxClients is the number of clients tracked at the same time.
+	maxClients int
+	// jobState returns the state of the job, to find a job completed before end records it.
+	jobState func(id string) JobState
+	mu       sync.Mutex
+	clients  map[string]*clientState
+	// owners are the clients of the jobs.
+	owners    map[string]string
+	lastPrune time.Time
+}
+
+func newClientLimiter(limits ClientLimits, jobState func(id string) JobState) *clientLimiter {
+	apiKeys := make(map[string]string, len(limits.ApiKeys))
+	for _, key := range limits.ApiKeys {
+		// Api keys are hashed so that admin_clientUsage does not reveal them.
+		hash := sha256.Sum256([]byte(key))
+		apiKeys[key] = "key:" + hex.EncodeToString(hash[:8])
+	}
+	return &clientLimiter{
+		limits:     limits,
+		apiKeys:    apiKeys,
+		maxClients: maxTrackedClients,
+		jobState:   jobState,
+		clients:    make(map[string]*clientState),
+		owners:     make(map[string]string),
+	}
+}
+
+// clientOf identifies the client of the request by its api key, if it is configured, or by its IP address.
+func (l *clientLimiter) clientOf(httpRequest *http.Request) string {
+	if client, ok := l.apiKeys[httpRequest.Header.Get("X-Api-Key")]; ok {
+		return client
+	}
+	host, _, err := net.SplitHostPort(httpRequest.RemoteAddr)
+	if err != nil {
+		host = httpRequest.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// begin admits a proving request of the client at now, or returns an error with a retry hint.
+// An admitted request must be finished with end.
+func (l *clientLimiter) begin(client string, method string, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	state := l.clients[client]
+	if state == nil {
+		if len(l.clients) >= l.maxClients && !l.evict() {
+			return newRateLimitError("too many clients", concurrencyRetryAfter)
+		}
+		state = &clientState{jobs: make(map[string]struct{})}
+		l.clients[client] = state
+	}
+	state.lastRequest = now
+	if err := l.limit(state, now); err != nil {
+		state.rejected++
+		return err
+	}
+	state.requests++
+	state.recent = append(state.recent, now)
+	if method == "prove" {
+		state.calls++
+	}
+	return nil
+}
+
+// check returns the error of begin for a proving request of the client at now, without admitting the request.
+// The request is read before begin, so that it is checked while it is read.
+func (l *clientLimiter) check(client string, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if state := l.clients[client]; state != nil {
+		return l.limit(state, now)
+	}
+	return nil
+}
+
+// reject counts a request of the client rejected by check.
+func (l *clientLimiter) reject(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if state := l.clients[client]; state != nil {
+		state.rejected++
+	}
+}
+
+// limit returns an error with a retry hint if the client is at a limit at now. l.mu must be held.
+func (l *clientLimiter) limit(state *clientState, now time.Time) error {
+	state.recent = recentRequests(state.recent, now)
+	if limit := l.limits.RequestsPerMinute; limit > 0 && len(state.recent) >= limit {
+		retryAfter := state.recent[len(state.recent)-limit].Add(rateLimitWindow).Sub(now)
+		return newRateLimitError(fmt.Sprintf("rate limit of %d requests per minute exceeded", limit), retryAfter)
+	}
+	if limit := l.limits.MaxConcurrentJobs; limit > 0 && state.activeJobs() >= limit {
+		return newRateLimitError(fmt.Sprintf("limit of %d concurrent jobs exceeded", limit), concurrencyRetryAfter)
+	}
+	return nil
+}
+
+// end finishes an admitted request. A job submitted by prove_submit counts against the client until it completes.
+func (l *clientLimiter) end(client string, method string, result any) {
+	status, ok := result.(*JobStatus)
+	if !l.record(client, method, status) || !ok {
+		return
+	}
+	// The job may have completed before it is recorded, so that its event is missed.
+	if state := l.jobState(status.Id); !isActiveJob(state) {
+		l.jobEvent(&JobEvent{Id: status.Id, State: state})
+	}
+}
+
+// record finishes the request and returns true if it records an active job.
+func (l *clientLimiter) record(client string, method string, status *JobStatus) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := l.clients[client]
+	if state == nil {
+		return false
+	}
+	switch method {
+	case "prove":
+		state.calls--
+	case "prove_submit":
+		if status != nil && isActiveJob(status.State) {
+			state.jobs[status.Id] = struct{}{}
+			l.owners[status.Id] = client
+			return true
+		}
+	}
+	return false
+}
+
+// jobEvent stops counting a completed job against its client. It listens to the job events of the services.
+func (l *clientLimiter) jobEvent(event any) {
+	jobEvent, ok := event.(*JobEvent)
+	if !ok || isActiveJob(jobEvent.State) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	client, ok := l.owners[jobEvent.Id]
+	if !ok {
+		return
+	}
+	delete(l.owners, jobEvent.Id)
+	if state := l.clients[client]; state != nil {
+		delete(state.jobs, jobEvent.Id)
+	}
+}
+
+// usage returns the usage of each client in client order.
+func (l *clientLimiter) usage(now time.Time) []*ClientUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	usages := make([]*ClientUsage, 0, len(l.clients))
+	for client, state := range l.clients {
+		state.recent = recentRequests(state.recent, now)
+		usages = append(usages, &ClientUsage{
+			Client:            client,
+			Requests:          state.requests,
+			Rejected:          state.rejected,
+			RequestsPerMinute: len(state.recent),
+			ActiveJobs:        state.activeJobs(),
+			LastRequestAt:     state.lastRequest,
+		})
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Client < usages[j].Client })
+	return usages
+}
+
+// activeJobs returns the number of the running prove calls and the unfinished jobs of the client.
+func (c *clientState) activeJobs() int {
+	return c.calls + len(c.jobs)
+}
+
+// prune forgets the clients idle for idleClientTimeout. It runs at most once per rateLimitWindow. l.mu must be held.
+func (l *clientLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < rateLimitWindow {
+		return
+	}
+	l.lastPrune = now
+	for client, state := range l.clients {
+		if now.Sub(state.lastRequest) >= idleClientTimeout && state.activeJobs() == 0 {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// evict forgets the least recently active client without jobs to make room for a new client.
+// It returns false if every client has jobs. l.mu must be held.
+func (l *clientLimiter) evict() bool {
+	var oldest string
+	for client, state := range l.clients {
+		if state.activeJobs() == 0 && (len(oldest) == 0 || state.lastRequest.Before(l.clients[oldest].lastRequest)) {
+			oldest = client
+		}
+	}
+	if len(oldest) == 0 {
+		return false
+	}
+	delete(l.clients, oldest)
+	return true
+}
+
+// recentRequests drops the request times before the window ending at now.
+func recentRequests(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= rateLimitWindow {
+		i++
+	}
+	return times[i:]
+}
+
+func isActiveJob(state JobState) bool {
+	return state == JobQueued || state == JobBooting || state == JobProving
+}
+
+func isProvingMethod(method string) bool {
+	return method == "prove" || method == "prove_submit"
+}
+
+// newRateLimitError returns an error with the number of seconds to wait before retrying in its data.
+func newRateLimitError(message string, retryAfter time.Duration) *JsonRpcError {
+	return &JsonRpcError{Code: rateLimitErrorCode, Message: message, Data: map[string]any{"retryAfter": retryAfterSeconds(retryAfter)}}
+}
+
+func retryAfterSeconds(retryAfter time.Duration) int {
+	return int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+}
+
+// setRetryAfter sets the Retry-After header if err is a rate limit error.
+func setRetryAfter(writer http.ResponseWriter, err error) {
+	rpcError := NewJsonRpcErrorFromErrorOrNil(err)
+	if rpcError == nil || rpcError.Code != rateLimitErrorCode {
+		return
+	}
+	if data, ok := rpcError.Data.(map[string]any); ok {
+		writer.Header().Set("Retry-After", strconv.Itoa(data["retryAfter"].(int)))
+	}
+}
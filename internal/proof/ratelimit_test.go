@@ -0,0 +1,167 @@
+package proof
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientRequestsPerMinute(t *testing.T) {
+	limiter := newClientLimiter(ClientLimits{RequestsPerMinute: 2}, func(string) JobState { return JobDone })
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := limiter.begin("ip:10.0.0.1", "prove_submit", now.Add(time.Duration(i)*10*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		limiter.end("ip:10.0.0.1", "prove_submit", &JobStatus{State: JobDone})
+	}
+	err := limiter.begin("ip:10.0.0.1", "prove_submit", now.Add(20*time.Second))
+	var rpcError *JsonRpcError
+	if !errors.As(err, &rpcError) || rpcError.Code != rateLimitErrorCode {
+		t.Fatalf("third request in a minute must be rejected. got %v", err)
+	}
+	if retryAfter := rpcError.Data.(map[string]any)["retryAfter"]; retryAfter != 40 {
+		t.Errorf("retry must be possible when the first request leaves the window. got %v", retryAfter)
+	}
+	if err := limiter.begin("ip:10.0.0.2", "prove_submit", now.Add(20*time.Second)); err != nil {
+		t.Errorf("other clients must not be limited. got %v", err)
+	}
+	if err := limiter.begin("ip:10.0.0.1", "prove_submit", now.Add(time.Minute)); err != nil {
+		t.Errorf("request must be admitted after the window. got %v", err)
+	}
+
+	usage := limiter.usage(now.Add(time.Minute))
+	if len(usage) != 2 || usage[0].Client != "ip:10.0.0.1" || usage[0].Requests != 3 || usage[0].Rejected != 1 || usage[0].RequestsPerMinute != 2 {
+		t.Errorf("unexpected usage %+v", usage[0])
+	}
+}
+
+func TestClientMaxConcurrentJobs(t *testing.T) {
+	states := map[string]JobState{"a": JobQueued, "b": JobProving}
+	limiter := newClientLimiter(ClientLimits{MaxConcurrentJobs: 2}, func(id string) JobState { return states[id] })
+	now := time.Now()
+	for _, id := range []string{"a", "b"} {
+		if err := limiter.begin("key:1", "prove_submit", now); err != nil {
+			t.Fatal(err)
+		}
+		limiter.end("key:1", "prove_submit", &JobStatus{Id: id, State: states[id]})
+	}
+	if err := limiter.begin("key:1", "prove", now); err == nil {
+		t.Fatal("request over the job limit must be rejected")
+	}
+	limiter.jobEvent(&JobEvent{Id: "a", State: JobDone})
+	if err := limiter.begin("key:1", "prove", now); err != nil {
+		t.Fatalf("completed jobs must not count. got %v", err)
+	}
+	if err := limiter.begin("key:1", "prove_submit", now); err == nil {
+		t.Fatal("running prove call must count as a job")
+	}
+	limiter.end("key:1", "prove", nil)
+	if usage := limiter.usage(now); usage[0].ActiveJobs != 1 {
+		t.Errorf("expected 1 active job. got %+v", usage[0])
+	}
+
+	// The job completes before the request ends.
+	states["c"] = JobDone
+	_ = limiter.begin("key:1", "prove_submit", now)
+	limiter.end("key:1", "prove_submit", &JobStatus{Id: "c", State: JobQueued})
+	if usage := limiter.usage(now); usage[0].ActiveJobs != 1 {
+		t.Errorf("job completed before it is recorded must not count. got %+v", usage[0])
+	}
+}
+
+func TestClientOf(t *testing.T) {
+	limiter := newClientLimiter(ClientLimits{ApiKeys: []string{"secret"}}, nil)
+	request := httptest.NewRequest("POST", "/", nil)
+	request.RemoteAddr = "10.0.0.1:5000"
+	if client := limiter.clientOf(request); client != "ip:10.0.0.1" {
+		t.Errorf("expected ip client. got %s", client)
+	}
+	request.Header.Set("X-Api-Key", "made-up")
+	if client := limiter.clientOf(request); client != "ip:10.0.0.1" {
+		t.Errorf("unknown api key must be identified by ip. got %s", client)
+	}
+	request.Header.Set("X-Api-Key", "secret")
+	if client := limiter.clientOf(request); !strings.HasPrefix(client, "key:") || strings.Contains(client, "secret") {
+		t.Errorf("expected hashed key client. got %s", client)
+	}
+}
+
+func TestMaxTrackedClients(t *testing.T) {
+	limiter := newClientLimiter(ClientLimits{}, func(string) JobState { return JobDone })
+	limiter.maxClients = 2
+	now := time.Now()
+	_ = limiter.begin("ip:10.0.0.1", "prove", now)
+	_ = limiter.begin("ip:10.0.0.2", "prove", now.Add(time.Second))
+	if err := limiter.begin("ip:10.0.0.3", "prove", now.Add(2*time.Second)); err == nil {
+		t.Errorf("new client must be rejected if every client has jobs")
+	}
+	limiter.end("ip:10.0.0.2", "prove", nil)
+	if err := limiter.begin("ip:10.0.0.3", "prove", now.Add(3*time.Second)); err != nil {
+		t.Fatalf("idle client must be evicted for a new client. got %v", err)
+	}
+	if usage := limiter.usage(now); len(usage) != 2 || usage[0].Client != "ip:10.0.0.1" || usage[1].Client != "ip:10.0.0.3" {
+		t.Errorf("client with a running prove call must be kept. got %+v", usage)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	withToken := NewServer(&Service{events: newEventFeed()}, WithAdminToken("admin-secret"))
+	withoutToken := NewServer(&Service{events: newEventFeed()})
+	for _, test := range []struct {
+		server        *Server
+		authorization string
+		served        bool
+	}{
+		{withToken, "", false},
+		{withToken, "Bearer wrong", false},
+		{withToken, "Bearer admin-secret", true},
+		{withoutToken, "Bearer ", false},
+	} {
+		request := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"admin_clientUsage","params":[]}`))
+		request.Header.Set("Authorization", test.authorization)
+		recorder := httptest.NewRecorder()
+		test.server.ServeHTTP(recorder, request)
+		var response struct{ Error *JsonRpcError }
+		_ = json.NewDecoder(recorder.Body).Decode(&response)
+		if (response.Error == nil) != test.served {
+			t.Errorf("admin_clientUsage with %q must be served: %v. got %+v", test.authorization, test.served, response.Error)
+		}
+	}
+}
+
+func TestLimitedClientDoesNotSpool(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{disk: newTestDiskRepository(t), events: newEventFeed(), inProgressProof: make(map[string]*job)}
+	server := NewServer(service, WithClientLimits(ClientLimits{RequestsPerMinute: 1}), WithSpoolDir(dir))
+	if err := server.clients.begin("ip:192.0.2.1", "prove_submit", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(body string) (*httptest.ResponseRecorder, *JsonRpcError) {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		var response struct{ Error *JsonRpcError }
+		_ = json.NewDecoder(recorder.Body).Decode(&response)
+		return recorder, response.Error
+	}
+
+	trace := `{"header":{"number":"0x10"},"padding":"` + strings.Repeat("0", 2*spoolThreshold) + `"}`
+	body, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "prove_submit", "params": []any{trace}})
+	recorder, rpcError := serve(string(body))
+	if rpcError == nil || rpcError.Code != rateLimitErrorCode || len(recorder.Header().Get("Retry-After")) == 0 {
+		t.Errorf("large trace of a limited client must be rejected with a retry hint. got %+v", rpcError)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("trace of a limited client must not be spooled. got %d files", len(entries))
+	}
+	if _, rpcError := serve(`{"jsonrpc":"2.0","id":2,"method":"proof_status","params":["` + testId("0") + `"]}`); rpcError != nil {
+		t.Errorf("small requests of a limited client must be served. got %+v", rpcError)
+	}
+	if usage := server.clients.usage(time.Now()); usage[0].Rejected != 1 {
+		t.Errorf("rejected trace must be counted. got %+v", usage[0])
+	}
+}
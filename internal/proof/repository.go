@@ -0,0 +1,23 @@
+package proof
+
+// Repository stores proofs and the spec of the prover.
+type Repository interface {
+	// Find returns nil if the proof is not stored or is corrupted.
+	Find(id string) *FileProof
+	// Meta returns nil if the proof is not stored.
+	Meta(id string) *ProofMeta
+	Save(id string, proof *FileProof) error
+	// List returns the metadata of the latest proofs, newest first. A non-positive limit lists all proofs.
+	List(limit int) []*ProofMeta
+	// ListByBlockRange returns the metadata of the proofs of the blocks from `from` to `to` inclusive in block order.
+	// A non-positive limit lists all proofs in the range.
+	ListByBlockRange(from, to uint64, limit int) []*ProofMeta
+	FindSpec() *SpecResponse
+	SaveSpec(spec *SpecResponse)
+	// FindCostReport returns nil if no cost report is saved.
+	FindCostReport() *CostReport
+	SaveCostReport(report *CostReport)
+	Close()
+}
+
+var _ Repository = (*DiskRepository)(nil)
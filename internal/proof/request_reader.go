@@ -0,0 +1,422 @@
+package proof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// rpcRequest is a json rpc request read by readRequest.
+type rpcRequest struct {
+	id        any
+	method    string
+	hasMethod bool
+	hasParams bool
+	// params are the parameters without the trace.
+	params any
+	// trace is the first positional parameter, or the trace parameter, if it is a string. It is streamed into a spool
+	// because it is the trace of prove and prove_submit.
+	trace *spooledTrace
+	// traceErr is the error reading the fields of the trace.
+	traceErr error
+	// named is set if the trace is given by name.
+	named bool
+}
+
+// streamedTrace are the parameters of prove and prove_submit with the trace read by readRequest.
+type streamedTrace struct {
+	trace *spooledTrace
+	err   error
+	// params are the parameters without the trace.
+	params any
+}
+
+// readRequest reads a json rpc request in a single pass. The trace is spooled to dir while it is read, so that
+// it is never held in memory as a whole. If memoryOnly is set, a trace larger than spoolThreshold fails with
+// errSpoolFull instead. The spooled trace must be released.
+func readRequest(reader io.Reader, dir string, memoryOnly bool) (*rpcRequest, error) {
+	r := &requestReader{reader: bufio.NewReaderSize(reader, 64<<10), dir: dir, memoryOnly: memoryOnly}
+	request := &rpcRequest{}
+	fields := make(map[string]json.RawMessage)
+	err := r.readObject(func(key string) error {
+		if key == "params" {
+			request.hasParams = true
+			return r.readParams(request)
+		}
+		raw, err := r.readRaw()
+		fields[key] = raw
+		return err
+	})
+	if err != nil {
+		if request.trace != nil {
+			request.trace.release()
+		}
+		return nil, err
+	}
+	if raw, ok := fields["id"]; ok {
+		_ = json.Unmarshal(raw, &request.id)
+	}
+	if raw, ok := fields["method"]; ok {
+		request.hasMethod = json.Unmarshal(raw, &request.method) == nil
+	}
+	return request, nil
+}
+
+// release removes the spooled trace of the request.
+func (r *rpcRequest) release() {
+	if r.trace != nil {
+		r.trace.release()
+	}
+}
+
+// callParams returns the parameters passed to the method. The trace is passed to the proving methods as a
+// streamedTrace, and inlined as a string to the other methods.
+func (r *rpcRequest) callParams() (any, error) {
+	if r.trace == nil {
+		return r.params, nil
+	}
+	if isProvingMethod(r.method) {
+		return &streamedTrace{trace: r.trace, err: r.traceErr, params: r.params}, nil
+	}
+	value, err := r.trace.data.String()
+	if err != nil {
+		return nil, err
+	}
+	if r.named {
+		r.params.(map[string]any)["trace"] = value
+		return r.params, nil
+	}
+	return append([]any{value}, r.params.([]any)...), nil
+}
+
+type requestReader struct {
+	reader     *bufio.Reader
+	dir        string
+	memoryOnly bool
+}
+
+func (r *requestReader) readParams(request *rpcRequest) error {
+	c, err := r.peek()
+	if err != nil {
+		return err
+	}
+	switch c {
+	case '[':
+		params := []any{}
+		err := r.readArray(func(index int) error {
+			if c, err := r.peek(); err != nil || index != 0 || c != '"' {
+				return r.readValue(func(value any) { params = append(params, value) })
+			}
+			return r.readTrace(request)
+		})
+		request.params = params
+		return err
+	case '{':
+		params := make(map[string]any)
+		err := r.readObject(func(key string) error {
+			if c, err := r.peek(); err != nil || key != "trace" || c != '"' || request.trace != nil {
+				return r.readValue(func(value any) { params[key] = value })
+			}
+			request.named = true
+			return r.readTrace(request)
+		})
+		request.params = params
+		return err
+	default:
+		return r.readValue(func(value any) { request.params = value })
+	}
+}
+
+// readTrace unescapes the string value into a spooled trace.
+func (r *requestReader) readTrace(request *rpcRequest) error {
+	if _, err := r.next(); err != nil {
+		return err
+	}
+	writer := newTraceWriter(r.dir)
+	writer.data.memoryOnly = r.memoryOnly
+	buffered := bufio.NewWriterSize(writer, 64<<10)
+	err := r.readString(buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		writer.abort()
+		return err
+	}
+	request.trace, request.traceErr = writer.close()
+	return nil
+}
+
+// readObject calls fn with the key of each member of the object. fn must read the value.
+func (r *requestReader) readObject(fn func(key string) error) error {
+	if err := r.expect('{'); err != nil {
+		return err
+	}
+	if c, err := r.peek(); err != nil || c == '}' {
+		_, _ = r.next()
+		return err
+	}
+	for {
+		if err := r.expect('"'); err != nil {
+			return err
+		}
+		var key bytes.Buffer
+		if err := r.readString(&key); err != nil {
+			return err
+		}
+		if err := r.expect(':'); err != nil {
+			return err
+		}
+		if err := fn(key.String()); err != nil {
+			return err
+		}
+		c, err := r.next()
+		if err != nil {
+			return err
+		}
+		if c == '}' {
+			return nil
+		}
+		if c != ',' {
+			return fmt.Errorf("unexpected character %q in object", c)
+		}
+	}
+}
+
+// readArray calls fn with the index of each element of the array. fn must read the element.
+func (r *requestReader) readArray(fn func(index int) error) error {
+	if err := r.expect('['); err != nil {
+		return err
+	}
+	if c, err := r.peek(); err != nil || c == ']' {
+		_, _ = r.next()
+		return err
+	}
+	for index := 0; ; index++ {
+		if err := fn(index); err != nil {
+			return err
+		}
+		c, err := r.next()
+		if err != nil {
+			return err
+		}
+		if c == ']' {
+			return nil
+		}
+		if c != ',' {
+			return fmt.Errorf("unexpected character %q in array", c)
+		}
+	}
+}
+
+// readValue decodes the next value like json.Unmarshal into an interface value.
+func (r *requestReader) readValue(fn func(value any)) error {
+	raw, err := r.readRaw()
+	if err != nil {
+		return err
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	fn(value)
+	return nil
+}
+
+// readRaw returns the next value as is. The value is validated when it is decoded.
+func (r *requestReader) readRaw() (json.RawMessage, error) {
+	c, err := r.peek()
+	if err != nil {
+		return nil, err
+	}
+	var raw []byte
+	depth, inString, escaped := 0, false, false
+	for {
+		c, err = r.reader.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if !inString && depth == 0 && len(raw) != 0 && (c == ',' || c == '}' || c == ']' || isSpace(c)) {
+			// The end of a literal.
+			return raw, r.reader.UnreadByte()
+		}
+		raw = append(raw, c)
+		switch {
+		case inString && escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+		if !inString && depth == 0 && (c == '"' || c == '}' || c == ']') {
+			return raw, nil
+		}
+	}
+}
+
+type stringWriter interface {
+	io.ByteWriter
+	WriteRune(r rune) (int, error)
+}
+
+// readString unescapes the string after its opening quote into writer.
+func (r *requestReader) readString(writer stringWriter) error {
+	for {
+		c, err := r.reader.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch {
+		case c == '"':
+			return nil
+		case c == '\\':
+			if err := r.readEscape(writer); err != nil {
+				return err
+			}
+		case c < 0x20:
+			return fmt.Errorf("invalid character %q in string", c)
+		case c >= utf8.RuneSelf:
+			// Invalid UTF-8 is replaced byte by byte like json.Unmarshal does, so that the id of the trace is the
+			// hash of the string json.Unmarshal decodes.
+			_ = r.reader.UnreadByte()
+			value, _, err := r.reader.ReadRune()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			if _, err := writer.WriteRune(value); err != nil {
+				return err
+			}
+		default:
+			if err := writer.WriteByte(c); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *requestReader) readEscape(writer stringWriter) error {
+	c, err := r.reader.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	switch c {
+	case '"', '\\', '/':
+		return writer.WriteByte(c)
+	case 'b':
+		return writer.WriteByte('\b')
+	case 'f':
+		return writer.WriteByte('\f')
+	case 'n':
+		return writer.WriteByte('\n')
+	case 'r':
+		return writer.WriteByte('\r')
+	case 't':
+		return writer.WriteByte('\t')
+	case 'u':
+		value, err := r.readHex()
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(value) {
+			// A surrogate pair is decoded as a single rune. A lone surrogate is replaced like json.Unmarshal does.
+			if next, err := r.reader.Peek(6); err == nil && next[0] == '\\' && next[1] == 'u' {
+				if low, ok := parseHex(next[2:]); ok {
+					if decoded := utf16.DecodeRune(value, low); decoded != utf8.RuneError {
+						_, _ = r.reader.Discard(6)
+						value = decoded
+					}
+				}
+			}
+			if utf16.IsSurrogate(value) {
+				value = utf8.RuneError
+			}
+		}
+		_, err = writer.WriteRune(value)
+		return err
+	default:
+		return fmt.Errorf("invalid escape character %q in string", c)
+	}
+}
+
+func (r *requestReader) readHex() (rune, error) {
+	digits := make([]byte, 4)
+	if _, err := io.ReadFull(r.reader, digits); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	value, ok := parseHex(digits)
+	if !ok {
+		return 0, fmt.Errorf("invalid unicode escape %s", digits)
+	}
+	return value, nil
+}
+
+func parseHex(digits []byte) (rune, bool) {
+	var value rune
+	for _, c := range digits {
+		switch {
+		case '0' <= c && c <= '9':
+			value = value<<4 | rune(c-'0')
+		case 'a' <= c && c <= 'f':
+			value = value<<4 | rune(c-'a'+10)
+		case 'A' <= c && c <= 'F':
+			value = value<<4 | rune(c-'A'+10)
+		default:
+			return 0, false
+		}
+	}
+	return value, true
+}
+
+// peek returns the next character after whitespace without reading it.
+func (r *requestReader) peek() (byte, error) {
+	for {
+		c, err := r.reader.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if !isSpace(c) {
+			return c, r.reader.UnreadByte()
+		}
+	}
+}
+
+// next reads the next character after whitespace.
+func (r *requestReader) next() (byte, error) {
+	if _, err := r.peek(); err != nil {
+		return 0, err
+	}
+	return r.reader.ReadByte()
+}
+
+func (r *requestReader) expect(expected byte) error {
+	c, err := r.next()
+	if err != nil {
+		return err
+	}
+	if c != expected {
+		return fmt.Errorf("expected %q, but got %q", expected, c)
+	}
+	return nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package proof
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRequestStreamsTrace(t *testing.T) {
+	trace := `{"chainID":255,"header":{"number":"0x10"},"note":"quote \" and pair 😀 and \\ done"}`
+	body, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      7,
+		"method":  "prove_submit",
+		"params":  map[string]any{"priority": "high", "trace": trace},
+	})
+	// A surrogate pair escape is decoded like json.Unmarshal does.
+	body = bytes.Replace(body, []byte("😀"), []byte(`\ud83d\ude00`), 1)
+	request, err := readRequest(bytes.NewReader(body), t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer request.trace.release()
+	if request.method != "prove_submit" || request.id != float64(7) || !request.named {
+		t.Errorf("unexpected request %+v", request)
+	}
+	params, _ := request.callParams()
+	spooled, options, err := traceParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spooled.id != computeId(trace) {
+		t.Errorf("id of the streamed trace must be the hash of the trace")
+	}
+	if spooled.info.BlockNumber != "0x10" || spooled.info.ChainId != 255 || options.Priority != PriorityHigh {
+		t.Errorf("unexpected trace %+v with options %+v", spooled.info, options)
+	}
+	if data, _ := spooled.data.String(); data != trace {
+		t.Errorf("spooled trace mismatch. got %s", data)
+	}
+}
+
+func TestReadRequestInlinesParams(t *testing.T) {
+	request, err := readRequest(strings.NewReader(`{"params":["0xabc", {"limit": 3}], "method":"proof_status", "id":"1"}`), t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer request.trace.release()
+	params, _ := request.callParams()
+	if id, _ := stringParam(params, 0); id != "0xabc" || len(params.([]any)) != 2 {
+		t.Errorf("string parameter of other methods must be inlined. got %v", params)
+	}
+	for _, invalid := range []string{`{"params":["abc"`, `{"params":["a\x01"]}`, `["prove"]`, `{"method":"prove",}`} {
+		if _, err := readRequest(strings.NewReader(invalid), t.TempDir(), false); err == nil {
+			t.Errorf("invalid request %q must be rejected", invalid)
+		}
+	}
+}
+
+func TestReadRequestSpoolsLargeTrace(t *testing.T) {
+	dir := t.TempDir()
+	trace := `{"header":{"number":"0x10"},"padding":"` + strings.Repeat("a", spoolThreshold) + `"}`
+	body, _ := json.Marshal(map[string]any{"method": "prove", "params": []any{trace}})
+	request, err := readRequest(bytes.NewReader(body), dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("large trace must be spooled to a file. got %d files", len(entries))
+	}
+
+	var sent bytes.Buffer
+	reader, _ := request.trace.data.open()
+	if err := writeProveRequest(&sent, reader); err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+	var decoded struct{ Params []string }
+	if err := json.Unmarshal(sent.Bytes(), &decoded); err != nil || len(decoded.Params) != 1 || decoded.Params[0] != trace {
+		t.Errorf("trace sent to the prover must be the spooled trace. err %v", err)
+	}
+
+	request.trace.release()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("released trace must be removed. got %d files", len(entries))
+	}
+}
+
+func TestServeJsonRpcMaxRequestSize(t *testing.T) {
+	server := NewServer(&Service{disk: newTestDiskRepository(t), events: newEventFeed(), inProgressProof: make(map[string]*job)},
+		WithMaxRequestSize(64), WithSpoolDir(t.TempDir()))
+	body := `{"jsonrpc":"2.0","id":1,"method":"prove","params":["` + strings.Repeat("a", 64) + `"]}`
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d. got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+}
+
+func TestProverClientStreamsTrace(t *testing.T) {
+	trace := `{"header":{"number":"0x10"},"data":"line\nbreak"}`
+	prover := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var decoded struct{ Params []string }
+		if err := json.NewDecoder(request.Body).Decode(&decoded); err != nil || len(decoded.Params) != 1 || decoded.Params[0] != trace {
+			t.Errorf("prover must receive the trace. got %v, err %v", decoded.Params, err)
+		}
+		writer.Write([]byte(`{"jsonrpc":"2.0","id":"0","result":{"final_pair":"AQ==","proof":"Ag=="}}`))
+	}))
+	defer prover.Close()
+	client, _ := NewProverClient(prover.URL)
+	if _, err := client.Prove(context.Background(), strings.NewReader(trace)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProverClientCanceled(t *testing.T) {
+	prover := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// The server notices the closed connection once the body is read.
+		_, _ = io.ReadAll(request.Body)
+		<-request.Context().Done()
+	}))
+	defer prover.Close()
+	client, _ := NewProverClient(prover.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := client.Prove(ctx, strings.NewReader(`{}`)); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled prove must fail with the cancellation. got %v", err)
+	}
+}
+
+// TestStreamedIdMatchesBaseline checks that the id of a streamed trace is the id the proxy computed before traces
+// were streamed, by decoding the request and hashing the trace string, so that stored proofs keep matching.
+func TestStreamedIdMatchesBaseline(t *testing.T) {
+	// The traces are given as escaped in the request body.
+	for _, escaped := range []string{
+		`{\"header\":{\"number\":\"0x10\"},\"data\":\"plain ascii\"}`,
+		`{\"header\":{\"number\":\"0x10\"},\"data\":\"\\\" \\\\ \\/ \\b \\f \\n \\r \\t\"}\n\t\/`,
+		`{\"header\":{\"number\":\"0x10\"},\"data\":\"\u00e9\u6F22\u2028\u0000\u007f\"}`,
+		`{\"header\":{\"number\":\"0x10\"},\"data\":\"é 漢字 😀 ` + "\u2028\u007f" + `\"}`,
+		`{\"header\":{\"number\":\"0x10\"},\"data\":\"\ud83d\ude00 \ud83d \ude00x \uDBFF\uDFFF \ud83d\u0041\"}`,
+		`{\"header\":{\"number\":\"0x10\"},\"data\":\"invalid ` + "\xff\xfe utf-8 \xe6\xbc and \xed\xa0\x80 \xf4\x90\x80\x80" + `\"}`,
+	} {
+		body := `{"jsonrpc":"2.0","id":1,"method":"prove","params":["` + escaped + `"]}`
+		var baseline struct{ Params []string }
+		if err := json.Unmarshal([]byte(body), &baseline); err != nil {
+			t.Fatalf("invalid test body %s: %v", body, err)
+		}
+		request, err := readRequest(strings.NewReader(body), t.TempDir(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.trace.id != computeId(baseline.Params[0]) {
+			data, _ := request.trace.data.String()
+			t.Errorf("id of %q mismatch. streamed %q, decoded %q", escaped, data, baseline.Params[0])
+		}
+		request.release()
+	}
+}
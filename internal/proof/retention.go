@@ -0,0 +1,99 @@
+package proof
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// RetentionPolicy limits the stored proofs of one outcome. A zero value disables the limit.
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxCount int
+	MaxBytes int64
+}
+
+type RetentionConfig struct {
+	Success RetentionPolicy
+	Error   RetentionPolicy
+	// Interval is the time between sweeps.
+	Interval time.Duration
+	// DryRun only logs the proofs to be deleted.
+	DryRun bool
+}
+
+func (c RetentionConfig) policy(meta *ProofMeta) RetentionPolicy {
+	if meta.Failed {
+		return c.Error
+	}
+	return c.Success
+}
+
+// StartRetention periodically deletes the proofs exceeding the policies until the repository is closed.
+func (r *DiskRepository) StartRetention(config RetentionConfig) {
+	if config.Interval <= 0 {
+		log.Panicf("invalid retention interval %s\n", config.Interval)
+	}
+	go r.scheduleRetention(config)
+}
+
+func (r *DiskRepository) scheduleRetention(config RetentionConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deleted := r.applyRetention(time.Now(), config)
+			if config.DryRun {
+				log.Printf("retention dry run. would delete proof count %d\n", len(deleted))
+			} else {
+				log.Printf("deleted old proof count %d\n", len(deleted))
+			}
+		case <-r.closeContext.Done():
+			return
+		}
+	}
+}
+
+// applyRetention deletes the proofs exceeding the policies at now, oldest first, and returns them.
+// In dry run, the proofs are returned without being deleted.
+func (r *DiskRepository) applyRetention(now time.Time, config RetentionConfig) []*ProofMeta {
+	var all []*ProofMeta
+	count := make(map[bool]int)
+	bytes := make(map[bool]int64)
+	err := r.index.ascend(func(meta *ProofMeta) bool {
+		all = append(all, meta)
+		count[meta.Failed]++
+		bytes[meta.Failed] += meta.Size
+		return true
+	})
+	if err != nil {
+		log.Println(fmt.Errorf("failed to read proof index: %w", err))
+		return nil
+	}
+	var deleted []*ProofMeta
+	for _, meta := range all {
+		policy := config.policy(meta)
+		reason := ""
+		switch {
+		case policy.MaxAge > 0 && now.Sub(meta.CreatedAt) > policy.MaxAge:
+			reason = "max age"
+		case policy.MaxCount > 0 && count[meta.Failed] > policy.MaxCount:
+			reason = "max count"
+		case policy.MaxBytes > 0 && bytes[meta.Failed] > policy.MaxBytes:
+			reason = "max bytes"
+		default:
+			continue
+		}
+		if config.DryRun {
+			log.Println("retention dry run. would delete proof.", "id:", meta.Id, "blockNumber:", meta.BlockNumber, "createdAt:", meta.CreatedAt, "reason:", reason)
+		} else if err := r.remove(meta.Id); err != nil {
+			log.Println(fmt.Errorf("failed to delete old proof %s: %w", meta.Id, err))
+			continue
+		}
+		count[meta.Failed]--
+		bytes[meta.Failed] -= meta.Size
+		deleted = append(deleted, meta)
+	}
+	return deleted
+}
@@ -0,0 +1,82 @@
+package proof
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetentionMaxAge(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	proofs, stepPerProof := disk.saveTestProof(10)
+	now := proofs[len(proofs)-1].CreatedAt.Add(stepPerProof)
+	config := RetentionConfig{Success: RetentionPolicy{MaxAge: time.Duration(len(proofs)/2)*stepPerProof + stepPerProof/2}}
+	deleted := disk.applyRetention(now, config)
+	if len(deleted) != len(proofs)/2 {
+		t.Errorf("deleted proof count mismatch. expected %v, but got %v", len(proofs)/2, len(deleted))
+	}
+	listed := disk.List(len(proofs))
+	if len(listed) != len(proofs)/2 {
+		t.Errorf("saved proof count mismatch. expected %v, but got %v", len(proofs)/2, len(listed))
+	}
+	for i := 0; i < len(proofs)/2; i++ {
+		if disk.Meta(testId(strconv.Itoa(i))) != nil {
+			t.Errorf("proof %d must be deleted", i)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(disk.baseDir, "*", "*"))
+	if len(files) != len(proofs)/2 {
+		t.Errorf("proof file count mismatch. expected %v, but got %v", len(proofs)/2, len(files))
+	}
+}
+
+func TestRetentionCountAndBytesPerOutcome(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		disk.Save(testId("ok-"+strconv.Itoa(i)), &FileProof{CreatedAt: now.Add(time.Duration(i) * time.Second), Proof: []byte("proof")})
+		disk.Save(testId("err-"+strconv.Itoa(i)), &FileProof{CreatedAt: now.Add(time.Duration(i) * time.Second), Error: "failed"})
+	}
+	size := disk.Meta(testId("ok-0")).Size
+	config := RetentionConfig{
+		Success: RetentionPolicy{MaxBytes: 2*size + size/2},
+		Error:   RetentionPolicy{MaxCount: 3},
+	}
+	deleted := disk.applyRetention(now, config)
+	var ids []string
+	for _, meta := range deleted {
+		ids = append(ids, meta.Id)
+	}
+	// Proofs created at the same time are deleted in id order.
+	expected := []string{testId("ok-0"), testId("err-0"), testId("ok-1")}
+	if len(ids) != len(expected) {
+		t.Fatalf("deleted proofs mismatch. expected %v, but got %v", expected, ids)
+	}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Errorf("deleted proofs mismatch. expected %v, but got %v", expected, ids)
+		}
+	}
+}
+
+func TestRetentionDryRun(t *testing.T) {
+	disk := newTestDiskRepository(t)
+	proofs, _ := disk.saveTestProof(3)
+	config := RetentionConfig{Success: RetentionPolicy{MaxCount: 1}, DryRun: true}
+	if deleted := disk.applyRetention(time.Now(), config); len(deleted) != 2 {
+		t.Errorf("reported proof count mismatch. expected 2, but got %v", len(deleted))
+	}
+	if listed := disk.List(10); len(listed) != len(proofs) {
+		t.Errorf("dry run must not delete proofs. got %v proofs", len(listed))
+	}
+	if path, _ := disk.path(testId("0")); !fileExists(path) {
+		t.Errorf("dry run must not delete proof files")
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
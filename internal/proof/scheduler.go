@@ -0,0 +1,144 @@
+package proof
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = []string{"low", "normal", "high", "urgent"}
+
+func ParsePriority(value string) (Priority, error) {
+	for i, name := range priorityNames {
+		if strings.EqualFold(value, name) {
+			return Priority(i), nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("invalid priority %s (%s)", value, strings.Join(priorityNames, ", "))
+}
+
+func (p Priority) String() string {
+	if p < 0 || int(p) >= len(priorityNames) {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+func (p Priority) MarshalText() ([]byte, error) { return []byte(p.String()), nil }
+
+func (p *Priority) UnmarshalText(text []byte) (err error) {
+	*p, err = ParsePriority(string(text))
+	return
+}
+
+type ProveOptions struct {
+	Priority Priority
+	// Deadline is the time by which the client needs the proof. Zero means no deadline.
+	Deadline time.Time
+	// CallbackUrl is notified when the proof is completed in addition to the configured webhooks.
+	CallbackUrl string
+	// ChainId selects the backend of a proxy serving several chains. Zero selects it by the chain id of the trace.
+	ChainId uint64
+}
+
+// jobQueue is a heap of jobs waiting to be dispatched to the prover.
+// Jobs are ordered by priority class, then by earliest deadline (jobs with a deadline first),
+// then by arrival.
+type jobQueue []*job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.options.Priority != b.options.Priority {
+		return a.options.Priority > b.options.Priority
+	}
+	if !a.options.Deadline.Equal(b.options.Deadline) {
+		if a.options.Deadline.IsZero() || b.options.Deadline.IsZero() {
+			return !a.options.Deadline.IsZero()
+		}
+		return a.options.Deadline.Before(b.options.Deadline)
+	}
+	return a.seq < b.seq
+}
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	j.index = -1
+	*q = old[:len(old)-1]
+	return j
+}
+
+// enqueue adds a new job to the queue. s.mu must be held.
+func (s *Service) enqueue(j *job) {
+	s.seq++
+	j.seq = s.seq
+	s.transition(j, JobQueued, nil)
+	heap.Push(&s.queue, j)
+	s.dispatch()
+}
+
+// escalate raises the priority or advances the deadline of a queued job
+// that is requested again with more urgent options. s.mu must be held.
+func (s *Service) escalate(j *job, options ProveOptions) {
+	if j.index < 0 {
+		return
+	}
+	changed := false
+	if options.Priority > j.options.Priority {
+		j.options.Priority = options.Priority
+		changed = true
+	}
+	if !options.Deadline.IsZero() && (j.options.Deadline.IsZero() || options.Deadline.Before(j.options.Deadline)) {
+		j.options.Deadline = options.Deadline
+		changed = true
+	}
+	if changed {
+		heap.Fix(&s.queue, j.index)
+	}
+}
+
+// demand returns the number of queued and running jobs. s.mu must be held.
+func (s *Service) demand() int {
+	return s.queue.Len() + s.running
+}
+
+// dispatch starts queued jobs in priority order while the prover has free slots. s.mu must be held.
+func (s *Service) dispatch() {
+	for (s.maxConcurrentProofs <= 0 || s.running < s.maxConcurrentProofs) && s.queue.Len() != 0 {
+		j := heap.Pop(&s.queue).(*job)
+		if !j.options.Deadline.IsZero() && time.Now().After(j.options.Deadline) {
+			log.Println("dispatch job past its deadline.", "blockNumber:", j.blockNumber, "id:", j.id, "deadline:", j.options.Deadline)
+		}
+		s.running++
+		s.transition(j, JobBooting, nil)
+		j.startedAt = time.Now()
+		s.cost.startJob(j.id)
+		go s.run(j)
+	}
+}
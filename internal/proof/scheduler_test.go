@@ -0,0 +1,126 @@
+package proof
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestJobQueueOrder(t *testing.T) {
+	now := time.Now()
+	// The only slot of the prover is busy, so that every job is queued.
+	s := &Service{cost: newCostAccountant(nil), events: newEventFeed(), maxConcurrentProofs: 1, running: 1}
+	for _, j := range []*job{
+		{id: "normal-1", options: ProveOptions{Priority: PriorityNormal}},
+		{id: "low", options: ProveOptions{Priority: PriorityLow, Deadline: now}},
+		{id: "normal-late", options: ProveOptions{Priority: PriorityNormal, Deadline: now.Add(time.Hour)}},
+		{id: "normal-2", options: ProveOptions{Priority: PriorityNormal}},
+		{id: "normal-early", options: ProveOptions{Priority: PriorityNormal, Deadline: now.Add(time.Minute)}},
+		{id: "urgent", options: ProveOptions{Priority: PriorityUrgent}},
+	} {
+		s.enqueue(j)
+	}
+	s.escalate(s.queue[indexOf(s.queue, "normal-2")], ProveOptions{Priority: PriorityHigh})
+
+	expected := []string{"urgent", "normal-2", "normal-early", "normal-late", "normal-1", "low"}
+	for _, id := range expected {
+		if j := heap.Pop(&s.queue).(*job); j.id != id {
+			t.Errorf("job order mismatch. expected %s, but got %s", id, j.id)
+		}
+	}
+}
+
+func indexOf(q jobQueue, id string) int {
+	for i, j := range q {
+		if j.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestQueueStatus(t *testing.T) {
+	s := &Service{cost: newCostAccountant(nil), events: newEventFeed(), inProgressProof: make(map[string]*job), maxConcurrentProofs: 1}
+	s.recordProveDuration(10 * time.Minute)
+	startedAt := time.Now().Add(-3 * time.Minute)
+	provingAt := startedAt.Add(time.Minute)
+	s.inProgressProof["running"] = &job{id: "running", index: -1, state: JobProving, startedAt: startedAt, provingAt: provingAt}
+	s.running = 1
+	for _, j := range []*job{{id: "queued-1"}, {id: "queued-2"}} {
+		s.inProgressProof[j.id] = j
+		s.enqueue(j)
+	}
+
+	if demand := s.demand(); demand != 3 {
+		t.Errorf("demand must count the queued and running jobs. expected 3, but got %d", demand)
+	}
+	statuses := s.QueueStatus()
+	if len(statuses) != 3 {
+		t.Fatalf("status count mismatch. expected 3, but got %d", len(statuses))
+	}
+	for i, expected := range []struct {
+		id       string
+		state    JobState
+		position int
+		eta      time.Time
+	}{
+		{"running", JobProving, 0, provingAt.Add(10 * time.Minute)},
+		{"queued-1", JobQueued, 1, provingAt.Add(20 * time.Minute)},
+		{"queued-2", JobQueued, 2, provingAt.Add(30 * time.Minute)},
+	} {
+		status := statuses[i]
+		if status.Id != expected.id || status.State != expected.state || status.QueuePosition != expected.position {
+			t.Errorf("status mismatch. expected %+v, but got %+v", expected, status)
+		}
+		if status.Eta == nil || !status.Eta.Equal(expected.eta) {
+			t.Errorf("eta of %s mismatch. expected %v, but got %v", expected.id, expected.eta, status.Eta)
+		}
+	}
+}
+
+func TestDefaultMaxConcurrentProofs(t *testing.T) {
+	// The queue is ordered by default, because a job is dispatched to each prover instance.
+	if s := NewService(newTestDiskRepository(t), &fakeFailoverController{}); s.maxConcurrentProofs != 1 {
+		t.Errorf("a job must be dispatched to the single prover instance. got %d", s.maxConcurrentProofs)
+	}
+	if s := NewService(newTestDiskRepository(t), &fakeFailoverController{}, WithMaxConcurrentProofs(-1)); s.maxConcurrentProofs > 0 {
+		t.Errorf("negative max must dispatch every job at once. got %d", s.maxConcurrentProofs)
+	}
+}
+
+// fakeDemandController sends each demand it is set to.
+type fakeDemandController struct {
+	fakeFailoverController
+	demands chan int
+}
+
+func (c *fakeDemandController) SetDemand(jobs int) { c.demands <- jobs }
+
+func TestApplyDemand(t *testing.T) {
+	controller := &fakeDemandController{demands: make(chan int)}
+	s := NewService(newTestDiskRepository(t), controller)
+	defer s.close()
+	s.mu.Lock()
+	s.running = 3
+	s.mu.Unlock()
+	s.setDemand()
+	if demand := <-controller.demands; demand != 3 {
+		t.Fatalf("demand mismatch. expected 3, but got %d", demand)
+	}
+
+	// The controller is slow while the demand changes several times. The updates are coalesced into the latest demand.
+	s.setDemand()
+	s.mu.Lock()
+	s.running = 1
+	s.mu.Unlock()
+	s.setDemand()
+	s.setDemand()
+	var demand int
+	for demand != 1 {
+		select {
+		case demand = <-controller.demands:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("latest demand must be applied. last %d", demand)
+		}
+	}
+}
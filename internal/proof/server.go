@@ -1,30 +1,182 @@
 package proof
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// defaultListLimit is the number of proofs listed when no limit is given.
+const defaultListLimit = 100
+
 type Server struct {
+	// service serves every request if the proxy serves a single chain.
 	service *Service
+	// backends serve the requests of each chain if the proxy serves several chains.
+	backends map[uint64]*Service
+	limits   ClientLimits
+	clients  *clientLimiter
+	// maxRequestSize is the maximum size of a json rpc request body. It is unlimited if it is not positive.
+	maxRequestSize int64
+	// spoolDir is the directory of the spooled traces. The default directory for temporary files is used if it is empty.
+	spoolDir string
+	// adminToken is the bearer token of the admin methods. The admin methods are disabled if it is empty.
+	adminToken string
+	// wsOrigins are the origins allowed to open a websocket in addition to the origin of the proxy.
+	wsOrigins []string
+}
+
+type ServerOption func(s *Server)
+
+// WithMaxRequestSize rejects json rpc requests larger than size bytes.
+func WithMaxRequestSize(size int64) ServerOption {
+	return func(s *Server) { s.maxRequestSize = size }
+}
+
+// WithSpoolDir spools large traces to dir until they are proven.
+func WithSpoolDir(dir string) ServerOption {
+	return func(s *Server) { s.spoolDir = dir }
+}
+
+// WithWebSocketOrigins allows web pages of origins, like "https://app.example.com", to open a websocket.
+func WithWebSocketOrigins(origins []string) ServerOption {
+	return func(s *Server) { s.wsOrigins = origins }
+}
+
+// WithAdminToken serves the admin methods, like admin_clientUsage, to requests with the bearer token.
+func WithAdminToken(token string) ServerOption {
+	return func(s *Server) { s.adminToken = token }
+}
+
+// WithClientLimits limits the proving requests of each client.
+func WithClientLimits(limits ClientLimits) ServerOption {
+	return func(s *Server) { s.limits = limits }
+}
+
+func NewServer(service *Service, options ...ServerOption) *Server {
+	return newServer(&Server{service: service}, options)
+}
+
+// NewMultiChainServer routes each request to the backend of its chain, given by the chainId parameter
+// or the chain id of the trace.
+func NewMultiChainServer(backends map[uint64]*Service, options ...ServerOption) *Server {
+	if len(backends) == 0 {
+		log.Panicln("chain backends are empty")
+	}
+	return newServer(&Server{backends: backends}, options)
+}
+
+func newServer(s *Server, options []ServerOption) *Server {
+	for _, option := range options {
+		option(s)
+	}
+	s.clients = newClientLimiter(s.limits, s.jobState)
+	for _, service := range s.services() {
+		service.events.listen(TopicJobEvents, s.clients.jobEvent)
+	}
+	return s
+}
+
+// services returns all backends in chain id order.
+func (s *Server) services() []*Service {
+	if s.backends == nil {
+		return []*Service{s.service}
+	}
+	var services []*Service
+	for _, chainId := range s.chainIds() {
+		services = append(services, s.backends[chainId])
+	}
+	return services
+}
+
+func (s *Server) chainIds() []uint64 {
+	chainIds := make([]uint64, 0, len(s.backends))
+	for chainId := range s.backends {
+		chainIds = append(chainIds, chainId)
+	}
+	sort.Slice(chainIds, func(i, j int) bool { return chainIds[i] < chainIds[j] })
+	return chainIds
+}
+
+// backend returns the backend of the chain. A zero chain id selects the backend only if there is one.
+func (s *Server) backend(chainId uint64) (*Service, error) {
+	if s.backends == nil {
+		return s.service, nil
+	}
+	if chainId == 0 {
+		if len(s.backends) == 1 {
+			return s.services()[0], nil
+		}
+		return nil, NewInvalidParamsError("chainId parameter is required", s.chainIds())
+	}
+	if backend, ok := s.backends[chainId]; ok {
+		return backend, nil
+	}
+	return nil, NewInvalidParamsError(fmt.Sprintf("unsupported chain id %d", chainId), s.chainIds())
+}
+
+// traceBackend returns the backend of the chainId option, or of the chain id of the trace.
+func (s *Server) traceBackend(trace *spooledTrace, options ProveOptions) (*Service, error) {
+	if options.ChainId != 0 && trace.info.ChainId != 0 && options.ChainId != trace.info.ChainId {
+		return nil, NewInvalidParamsError(fmt.Sprintf("chainId %d does not match chain id %d of the trace", options.ChainId, trace.info.ChainId), nil)
+	}
+	if s.backends == nil || options.ChainId != 0 {
+		return s.backend(options.ChainId)
+	}
+	return s.backend(trace.info.ChainId)
+}
+
+// jobState returns the state of the job or the proof of id in any backend.
+func (s *Server) jobState(id string) JobState {
+	for _, service := range s.services() {
+		if state := service.Status(id).State; state != JobUnknown {
+			return state
+		}
+	}
+	return JobUnknown
 }
 
-func NewServer(service *Service) *Server {
-	return &Server{service: service}
+// idBackend returns the backend which knows the job or the proof of id. It returns nil if none does.
+func (s *Server) idBackend(id string) *Service {
+	for _, service := range s.services() {
+		if service.Status(id).State != JobUnknown {
+			return service
+		}
+	}
+	return nil
 }
 
 func (s *Server) ServeHTTP(writer http.ResponseWriter, httpRequest *http.Request) {
 	switch httpRequest.RequestURI {
 	case "/":
 		s.serveJsonRpc(writer, httpRequest)
+	case "/ws":
+		s.serveWebSocket(writer, httpRequest)
+	case "/metrics":
+		promhttp.Handler().ServeHTTP(writer, httpRequest)
 	case "/health":
-		response := map[string]interface{}{
-			"status":               "ok",
-			"ec2Running":           s.service.ec2.Running(),
-			"generatingProofCount": len(s.service.inProgressProof),
+		response := map[string]interface{}{"status": "ok"}
+		if s.backends == nil {
+			for k, v := range s.service.health() {
+				response[k] = v
+			}
+		} else {
+			chains := make(map[string]interface{})
+			for chainId, backend := range s.backends {
+				chains[strconv.FormatUint(chainId, 10)] = backend.health()
+			}
+			response["chains"] = chains
 		}
 		err := json.NewEncoder(writer).Encode(response)
 		if err != nil {
@@ -33,32 +185,92 @@ func (s *Server) ServeHTTP(writer http.ResponseWriter, httpRequest *http.Request
 	}
 }
 
+func (s *Service) health() map[string]interface{} {
+	queue := s.QueueStatus()
+	queued := 0
+	for _, status := range queue {
+		if status.State == JobQueued {
+			queued++
+		}
+	}
+	return map[string]interface{}{
+		"ec2Running":           s.ec2.Running(),
+		"generatingProofCount": len(queue) - queued,
+		"queuedProofCount":     queued,
+		"maxConcurrentProofs":  s.maxConcurrentProofs,
+		"circuitBreaker":       s.breaker.Status(),
+		"jobs":                 queue,
+	}
+}
+
+// serveJsonRpc reads the request in a single pass. The trace of prove and prove_submit is spooled instead of
+// being decoded into memory.
 func (s *Server) serveJsonRpc(writer http.ResponseWriter, httpRequest *http.Request) {
-	var request map[string]interface{}
-	err := json.NewDecoder(httpRequest.Body).Decode(&request)
+	body := httpRequest.Body
+	if s.maxRequestSize > 0 {
+		body = http.MaxBytesReader(writer, body, s.maxRequestSize)
+	}
+	caller := s.callerOf(httpRequest)
+	request, err := s.readCall(body, caller.client)
+	if rpcError := NewJsonRpcErrorFromErrorOrNil(err); rpcError != nil {
+		setRetryAfter(writer, err)
+		if err := json.NewEncoder(writer).Encode(newResponse(nil, nil, err)); err != nil {
+			http.Error(writer, "Failed to encode JSON response", http.StatusInternalServerError)
+		}
+		return
+	}
 	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(writer, fmt.Sprintf("Request exceeds %d bytes", maxBytesError.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(writer, "Failed to decode JSON request", http.StatusBadRequest)
 		return
 	}
+	defer request.release()
 
-	method, ok := request["method"].(string)
-	if !ok {
+	if !request.hasMethod {
 		http.Error(writer, "Method not found in JSON request", http.StatusBadRequest)
 		return
 	}
-
-	params, ok := request["params"]
-	if !ok {
+	if !request.hasParams {
 		http.Error(writer, "Params not found in JSON request", http.StatusBadRequest)
 		return
 	}
+	params, err := request.callParams()
+	if err != nil {
+		http.Error(writer, "Failed to read spooled params", http.StatusInternalServerError)
+		return
+	}
 
+	result, err := s.call(caller, request.method, params)
+	setRetryAfter(writer, err)
+	err = json.NewEncoder(writer).Encode(newResponse(request.id, result, err))
+	if err != nil {
+		http.Error(writer, "Failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// readCall reads a json rpc request of the client. The trace of a client at its limits is kept in memory,
+// and a larger trace is rejected with the limit error, so that a limited client cannot fill the spool dir.
+func (s *Server) readCall(reader io.Reader, client string) (*rpcRequest, error) {
+	limited := s.clients.check(client, time.Now())
+	request, err := readRequest(reader, s.spoolDir, limited != nil)
+	if errors.Is(err, errSpoolFull) {
+		log.Println("reject request.", "client:", client, "err:", limited)
+		s.clients.reject(client)
+		return nil, limited
+	}
+	return request, err
+}
+
+func newResponse(id any, result any, err error) map[string]interface{} {
 	response := map[string]interface{}{
 		"jsonrpc": "2.0",
-		"id":      request["id"],
+		"id":      id,
 	}
-
-	if result, err := s.callMethod(method, params); err != nil {
+	if err != nil {
 		rpcError := NewJsonRpcErrorFromErrorOrNil(err)
 		if rpcError == nil {
 			rpcError = NewJsonRpcErrorFromString(err.Error())
@@ -67,31 +279,310 @@ func (s *Server) serveJsonRpc(writer http.ResponseWriter, httpRequest *http.Requ
 	} else {
 		response["result"] = result
 	}
+	return response
+}
 
-	err = json.NewEncoder(writer).Encode(response)
-	if err != nil {
-		http.Error(writer, "Failed to encode JSON response", http.StatusInternalServerError)
+// caller is the client of a request.
+type caller struct {
+	client string
+	// admin is set if the request has the admin token.
+	admin bool
+}
+
+func (s *Server) callerOf(httpRequest *http.Request) caller {
+	token, found := strings.CutPrefix(httpRequest.Header.Get("Authorization"), "Bearer ")
+	return caller{
+		client: s.clients.clientOf(httpRequest),
+		admin:  len(s.adminToken) != 0 && found && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1,
+	}
+}
+
+// call serves the method for the caller. The proving methods are subject to the limits of the client,
+// and the admin methods are served only to admins.
+func (s *Server) call(caller caller, method string, params interface{}) (any, error) {
+	if strings.HasPrefix(method, "admin_") && !caller.admin {
+		log.Println("reject admin request.", "client:", caller.client, "method:", method)
+		return nil, NewJsonRpcErrorFromString(fmt.Sprintf("method %s requires the admin token", method))
+	}
+	if !isProvingMethod(method) {
+		return s.callMethod(method, params)
+	}
+	if err := s.clients.begin(caller.client, method, time.Now()); err != nil {
+		log.Println("reject request.", "client:", caller.client, "method:", method, "err:", err)
+		return nil, err
 	}
+	result, err := s.callMethod(method, params)
+	s.clients.end(caller.client, method, result)
+	return result, err
 }
 
 func (s *Server) callMethod(method string, params interface{}) (any, error) {
 	switch method {
-	case "prove":
-		log.Println("prove requested")
-		p := params.([]any)
-		traceString, traceStringOk := p[0].(string)
-		if !traceStringOk {
-			return nil, errors.New("failed to read traceString parameter")
-		}
-		return s.service.Prove(traceString)
+	case "prove", "prove_submit":
+		log.Println(method, "requested")
+		trace, options, err := traceParams(params)
+		if err != nil {
+			return nil, err
+		}
+		defer trace.release()
+		backend, err := s.traceBackend(trace, options)
+		if err != nil {
+			return nil, err
+		}
+		if method == "prove" {
+			return backend.proveTrace(trace, options)
+		}
+		return backend.submitTrace(trace, options)
 	case "spec":
 		log.Println("spec requested")
-		return s.service.Spec()
+		refresh, _ := boolParam(params, 0, "refresh")
+		backend, err := s.backend(chainIdParam(params, 1))
+		if err != nil {
+			return nil, err
+		}
+		return backend.Spec(refresh)
+	case "proof_status":
+		id, ok := stringParam(params, 0)
+		if !ok {
+			return nil, NewInvalidParamsError("failed to read id parameter", nil)
+		}
+		if backend := s.idBackend(id); backend != nil {
+			return backend.Status(id), nil
+		}
+		return &JobStatus{Id: id, State: JobUnknown}, nil
+	case "proof_result":
+		id, ok := stringParam(params, 0)
+		if !ok {
+			return nil, NewInvalidParamsError("failed to read id parameter", nil)
+		}
+		if !validProofId(id) {
+			return nil, NewInvalidParamsError(fmt.Sprintf("invalid proof id %q", id), nil)
+		}
+		if backend := s.idBackend(id); backend != nil {
+			return backend.Result(id)
+		}
+		return nil, NewJsonRpcErrorFromString(fmt.Sprintf("proof %s is not found", id))
+	case "proof_list":
+		limit, ok := intParam(params, 0, "limit")
+		if !ok || limit <= 0 {
+			limit = defaultListLimit
+		}
+		backend, err := s.backend(chainIdParam(params, 1))
+		if err != nil {
+			return nil, err
+		}
+		return backend.disk.List(limit), nil
+	case "proof_getByBlockNumber":
+		blockNumber, err := blockNumberParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		backend, err := s.backend(chainIdParam(params, 1))
+		if err != nil {
+			return nil, err
+		}
+		return backend.ProofsByBlockNumber(blockNumber), nil
+	case "proof_listByBlockRange":
+		from, err := blockNumberParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		to, err := blockNumberParam(params, 1)
+		if err != nil {
+			return nil, err
+		}
+		if to < from {
+			return nil, NewInvalidParamsError(fmt.Sprintf("invalid block range %d-%d", from, to), nil)
+		}
+		limit, ok := intParam(params, 2, "limit")
+		if !ok || limit <= 0 {
+			limit = defaultListLimit
+		}
+		backend, err := s.backend(chainIdParam(params, 3))
+		if err != nil {
+			return nil, err
+		}
+		return backend.ListByBlockRange(from, to, limit), nil
+	case "proxy_costReport":
+		id, _ := stringParam(params, 0)
+		if len(id) != 0 && !validProofId(id) {
+			return nil, NewInvalidParamsError(fmt.Sprintf("invalid proof id %q", id), nil)
+		}
+		backend := s.idBackend(id)
+		if backend == nil {
+			var err error
+			if backend, err = s.backend(chainIdParam(params, 1)); err != nil {
+				return nil, err
+			}
+		}
+		return backend.CostReport(id)
+	case "admin_clientUsage":
+		return s.clients.usage(time.Now()), nil
 	default:
 		return nil, fmt.Errorf("unsupported method %s", method)
 	}
 }
 
+// traceParams returns the trace and the options of prove, streamed by serveJsonRpc or given as in proveParams.
+func traceParams(params any) (*spooledTrace, ProveOptions, error) {
+	streamed, ok := params.(*streamedTrace)
+	if !ok {
+		traceString, options, err := proveParams(params)
+		if err != nil {
+			return nil, options, err
+		}
+		trace, err := newTrace(traceString)
+		return trace, options, err
+	}
+	options := ProveOptions{Priority: PriorityNormal}
+	if named, ok := streamed.params.(map[string]any); ok {
+		var err error
+		if options, err = proveOptions(named); err != nil {
+			return nil, options, err
+		}
+	}
+	if streamed.err != nil {
+		return nil, options, NewInvalidParamsError(streamed.err.Error(), nil)
+	}
+	return streamed.trace, options, nil
+}
+
+// proveParams reads the parameters of prove, given either positionally as [traceString] or by name as
+// {"trace": traceString, "priority": "high", "deadline": "2023-07-01T00:00:00Z", "callbackUrl": "https://...", "chainId": 255}.
+// The deadline may also be given in unix seconds.
+func proveParams(params any) (string, ProveOptions, error) {
+	options := ProveOptions{Priority: PriorityNormal}
+	if traceString, ok := stringParam(params, 0); ok {
+		return traceString, options, nil
+	}
+	named, ok := params.(map[string]any)
+	if !ok {
+		return "", options, NewInvalidParamsError("failed to read traceString parameter", nil)
+	}
+	traceString, ok := named["trace"].(string)
+	if !ok {
+		return "", options, NewInvalidParamsError("failed to read trace parameter", nil)
+	}
+	options, err := proveOptions(named)
+	return traceString, options, err
+}
+
+// proveOptions reads the named options of prove.
+func proveOptions(named map[string]any) (ProveOptions, error) {
+	options := ProveOptions{Priority: PriorityNormal}
+	if value, ok := named["priority"]; ok {
+		priority, isString := value.(string)
+		parsed, err := ParsePriority(priority)
+		if !isString || err != nil {
+			return options, NewInvalidParamsError(fmt.Sprintf("invalid priority %v", value), priorityNames)
+		}
+		options.Priority = parsed
+	}
+	switch deadline := named["deadline"].(type) {
+	case nil:
+	case string:
+		parsed, err := time.Parse(time.RFC3339, deadline)
+		if err != nil {
+			return options, NewInvalidParamsError(fmt.Sprintf("invalid deadline %s", deadline), nil)
+		}
+		options.Deadline = parsed
+	case float64:
+		options.Deadline = time.Unix(int64(deadline), 0)
+	default:
+		return options, NewInvalidParamsError(fmt.Sprintf("invalid deadline %v", deadline), nil)
+	}
+	if value, ok := named["callbackUrl"]; ok {
+		callbackUrl, isString := value.(string)
+		parsed, err := url.Parse(callbackUrl)
+		if !isString || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+			return options, NewInvalidParamsError(fmt.Sprintf("invalid callbackUrl %v", value), nil)
+		}
+		options.CallbackUrl = callbackUrl
+	}
+	if value, ok := named["chainId"]; ok {
+		chainId, isNumber := value.(float64)
+		if !isNumber || chainId <= 0 || chainId != float64(uint64(chainId)) {
+			return options, NewInvalidParamsError(fmt.Sprintf("invalid chainId %v", value), nil)
+		}
+		options.ChainId = uint64(chainId)
+	}
+	return options, nil
+}
+
+// chainIdParam returns the optional chain id parameter at index, or given by name in an object. It returns zero if it is missing.
+func chainIdParam(params any, index int) uint64 {
+	chainId, ok := intParam(params, index, "chainId")
+	if !ok || chainId < 0 {
+		return 0
+	}
+	return uint64(chainId)
+}
+
+// stringParam returns the positional string parameter at index.
+func stringParam(params any, index int) (string, bool) {
+	p, ok := params.([]any)
+	if !ok || len(p) <= index {
+		return "", false
+	}
+	value, ok := p[index].(string)
+	return value, ok
+}
+
+// boolParam returns the positional bool parameter at index, or the named parameter
+// given as an object either directly or at index.
+func boolParam(params any, index int, name string) (bool, bool) {
+	if p, ok := params.([]any); ok {
+		if len(p) <= index {
+			return false, false
+		}
+		params = p[index]
+	}
+	if p, ok := params.(map[string]any); ok {
+		params = p[name]
+	}
+	value, ok := params.(bool)
+	return value, ok
+}
+
+// blockNumberParam returns the positional block number parameter at index, given in hex, in decimal or as a number.
+func blockNumberParam(params any, index int) (uint64, error) {
+	p, ok := params.([]any)
+	if !ok || len(p) <= index {
+		return 0, NewInvalidParamsError("failed to read block number parameter", nil)
+	}
+	switch value := p[index].(type) {
+	case string:
+		number, err := ParseBlockNumber(value)
+		if err != nil {
+			return 0, NewInvalidParamsError(err.Error(), nil)
+		}
+		return number, nil
+	case float64:
+		if value >= 0 && value == float64(uint64(value)) {
+			return uint64(value), nil
+		}
+	}
+	return 0, NewInvalidParamsError(fmt.Sprintf("invalid block number %v", p[index]), nil)
+}
+
+// intParam returns the positional number parameter at index, or the named parameter
+// given as an object either directly or at index.
+func intParam(params any, index int, name string) (int, bool) {
+	if p, ok := params.([]any); ok {
+		if len(p) <= index {
+			return 0, false
+		}
+		params = p[index]
+	}
+	if p, ok := params.(map[string]any); ok {
+		params = p[name]
+	}
+	value, ok := params.(float64)
+	return int(value), ok
+}
+
 func (s *Server) Close() {
-	s.service.Close()
+	for _, service := range s.services() {
+		service.Close()
+	}
 }
@@ -0,0 +1,70 @@
+package proof
+
+import (
+	"testing"
+)
+
+func TestMultiChainRouting(t *testing.T) {
+	newBackend := func(chainId uint64) *Service {
+		return &Service{chainId: chainId, disk: newTestDiskRepository(t), events: newEventFeed(), inProgressProof: make(map[string]*job)}
+	}
+	server := NewMultiChainServer(map[uint64]*Service{255: newBackend(255), 2358: newBackend(2358)})
+	trace := `{"chainID":2358,"header":{"number":"0x10"}}`
+	server.backends[2358].disk.Save(computeId(trace), &FileProof{BlockNumber: "0x10", Proof: []byte("proof")})
+
+	result, err := server.callMethod("prove_submit", []any{trace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := result.(*JobStatus); status.State != JobDone {
+		t.Errorf("cached proof of chain 2358 must be found. got %+v", status)
+	}
+	result, _ = server.callMethod("proof_status", []any{computeId(trace)})
+	if status := result.(*JobStatus); status.State != JobDone {
+		t.Errorf("status must be found in any backend. got %+v", status)
+	}
+	listed, _ := server.callMethod("proof_list", map[string]any{"chainId": float64(255)})
+	if len(listed.([]*ProofMeta)) != 0 {
+		t.Errorf("proofs of chain 2358 must not be listed in chain 255. got %v", listed)
+	}
+
+	if _, err := server.callMethod("prove_submit", []any{`{"chainID":1,"header":{"number":"0x10"}}`}); err == nil {
+		t.Errorf("trace of an unsupported chain must be rejected")
+	}
+	if _, err := server.callMethod("prove_submit", map[string]any{"trace": `{"header":{"number":"0x10"}}`}); err == nil {
+		t.Errorf("trace without chain id must be rejected if several chains are served")
+	}
+	if _, err := server.callMethod("prove_submit", map[string]any{"trace": trace, "chainId": float64(255)}); err == nil {
+		t.Errorf("chainId which differs from the chain id of the trace must be rejected")
+	}
+	if _, err := server.callMethod("spec", []any{false}); err == nil {
+		t.Errorf("spec without chain id must be rejected if several chains are served")
+	}
+}
+
+func TestRejectInvalidProofIdParam(t *testing.T) {
+	server := NewServer(&Service{disk: newTestDiskRepository(t), events: newEventFeed(), inProgressProof: make(map[string]*job)})
+	for _, method := range []string{"proxy_costReport", "proof_result"} {
+		_, err := server.callMethod(method, []any{"../../etc/passwd"})
+		if rpcError := NewJsonRpcErrorFromErrorOrNil(err); rpcError == nil || rpcError.Code != -32602 {
+			t.Errorf("invalid id of %s must be rejected as invalid params. got %v", method, err)
+		}
+	}
+}
+
+func TestCallbackAllowedHosts(t *testing.T) {
+	service := &Service{disk: newTestDiskRepository(t), events: newEventFeed(), inProgressProof: make(map[string]*job), callbackHosts: []string{"hooks.example.com"}}
+	server := NewServer(service)
+	trace := `{"header":{"number":"0x10"}}`
+	service.disk.Save(computeId(trace), &FileProof{BlockNumber: "0x10", Proof: []byte("proof")})
+
+	for _, callbackUrl := range []string{"http://169.254.169.254/latest/meta-data", "http://localhost:8545", "https://hooks.example.com.evil.io/"} {
+		_, err := server.callMethod("prove_submit", map[string]any{"trace": trace, "callbackUrl": callbackUrl})
+		if rpcError := NewJsonRpcErrorFromErrorOrNil(err); rpcError == nil || rpcError.Code != -32602 {
+			t.Errorf("callback to %s must be rejected. got %v", callbackUrl, err)
+		}
+	}
+	if _, err := server.callMethod("prove_submit", map[string]any{"trace": trace}); err != nil {
+		t.Errorf("request without callback must be accepted. got %v", err)
+	}
+}
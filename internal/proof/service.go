@@ -1,122 +1,649 @@
 package proof
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/kroma-network/kroma-prover-proxy/internal/ec2"
+	"github.com/kroma-network/kroma-prover-proxy/internal/webhook"
 )
 
+// InstanceController manages the lifecycle of the instance running the prover.
+type InstanceController interface {
+	StartIfNotRunning() error
+	StopIfRunning()
+	IpAddress() string
+	Running() bool
+}
+
+// interruptibleController is implemented by controllers whose instance can be reclaimed while proving.
+type interruptibleController interface {
+	// Interruption returns a channel that is closed when the running instance is interrupted.
+	Interruption() <-chan struct{}
+}
+
+// cancelableController is implemented by controllers whose start waits for the status checks of the instance.
+type cancelableController interface {
+	// CancelStart makes the start in progress, if any, fail.
+	CancelStart()
+}
+
+// demandController is implemented by controllers that scale with the number of queued and in-progress jobs.
+type demandController interface {
+	SetDemand(jobs int)
+}
+
+// capacityController is implemented by controllers managing several instances, which prove at the same time.
+type capacityController interface {
+	// Capacity returns the largest number of instances.
+	Capacity() int
+}
+
+// leasingController is implemented by controllers managing several instances.
+// A job leases the address of one instance, which is kept until release is called.
+type leasingController interface {
+	Lease() (address string, release func(), err error)
+}
+
+// failoverController is implemented by controllers that move the prover to another region
+// when its server does not become ready in time.
+type failoverController interface {
+	ReadyTimeout() time.Duration
+	// Active returns the position of the active instance.
+	Active() int
+	// Failover moves away from the instance at from, unless another caller has already done so.
+	// It returns false if there is no other region to move to.
+	Failover(from int) bool
+}
+
+// regionController is implemented by controllers that know the region of the prover instance.
+type regionController interface {
+	Region() string
+}
+
+type job struct {
+	id          string
+	blockNumber string
+	trace       *spooledTrace
+	options     ProveOptions
+	seq         uint64
+	index       int
+	state       JobState
+	startedAt   time.Time
+	// provingAt is the time the trace is sent to the prover. It is zero while the job is booting.
+	provingAt time.Time
+	callbacks []string
+	wg        sync.WaitGroup
+	proof     *FileProof
+	err       error
+}
+
+// eventSource is implemented by controllers emitting instance events.
+type eventSource interface {
+	Subscribe(fn func(ec2.Event))
+}
+
 type Service struct {
-	disk            *DiskRepository
-	ec2             *ec2.Controller
+	// chainId is the chain of the prover. It is zero if the proxy serves a single chain.
+	chainId     uint64
+	disk        Repository
+	ec2         InstanceController
+	cost        *costAccountant
+	events      *eventFeed
+	prewarm     []PrewarmWindow
+	webhooks    *webhook.Outbox
+	webhookUrls []string
+	// callbackHosts are the hosts allowed in the callback url of a request.
+	callbackHosts   []string
 	mu              sync.Mutex
-	inProgressProof map[string]*sync.WaitGroup
+	inProgressProof map[string]*job
+	queue           jobQueue
+	seq             uint64
+	running         int
+	durations       []time.Duration
+	// maxConcurrentProofs is the number of jobs dispatched to the prover at the same time. It is unlimited if it is not
+	// positive.
+	maxConcurrentProofs int
+	breaker             *circuitBreaker
+	breakerThreshold    int
+	breakerCooldown     time.Duration
+	// demandChanged wakes up applyDemand. It is nil if the controller does not scale with the demand.
+	demandChanged chan struct{}
+	closeContext  context.Context
+	close         context.CancelFunc
+}
+
+type ServiceOption func(s *Service)
+
+// WithHourlyPrices sets the hourly price per instance type used for cost accounting.
+func WithHourlyPrices(prices map[string]float64) ServiceOption {
+	return func(s *Service) { s.cost = newCostAccountant(prices) }
 }
 
-func NewService(disk *DiskRepository, ec2 *ec2.Controller) *Service {
-	return &Service{
+// WithChainId sets the chain of the prover, when the proxy serves several chains.
+func WithChainId(chainId uint64) ServiceOption {
+	return func(s *Service) { s.chainId = chainId }
+}
+
+// WithPrewarmWindows keeps the instance running during the windows, starting it when they begin.
+func WithPrewarmWindows(windows []PrewarmWindow) ServiceOption {
+	return func(s *Service) { s.prewarm = windows }
+}
+
+// WithMaxConcurrentProofs sets the number of jobs dispatched to the prover at the same time. If it is zero, a job is
+// dispatched to each prover instance. If it is negative, every job is dispatched at once, so that the queue is not
+// ordered by priority and deadline.
+func WithMaxConcurrentProofs(max int) ServiceOption {
+	return func(s *Service) { s.maxConcurrentProofs = max }
+}
+
+// WithCircuitBreaker rejects jobs for cooldown after threshold consecutive failures of the prover.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.breakerThreshold = threshold
+		s.breakerCooldown = cooldown
+	}
+}
+
+// WithWebhooks notifies urls, and the callback url of each request, of completed proofs through outbox.
+func WithWebhooks(outbox *webhook.Outbox, urls []string) ServiceOption {
+	return func(s *Service) {
+		s.webhooks = outbox
+		s.webhookUrls = urls
+	}
+}
+
+// WithCallbackAllowedHosts allows the callback url of a request only on hosts. Callbacks are rejected without them,
+// so that clients cannot make the proxy post to internal addresses.
+func WithCallbackAllowedHosts(hosts []string) ServiceOption {
+	return func(s *Service) { s.callbackHosts = hosts }
+}
+
+func NewService(disk Repository, controller InstanceController, options ...ServiceOption) *Service {
+	s := &Service{
 		disk:            disk,
-		ec2:             ec2,
-		inProgressProof: make(map[string]*sync.WaitGroup),
+		ec2:             controller,
+		cost:            newCostAccountant(nil),
+		events:          newEventFeed(),
+		inProgressProof: make(map[string]*job),
+	}
+	s.closeContext, s.close = context.WithCancel(context.Background())
+	for _, option := range options {
+		option(s)
+	}
+	if s.maxConcurrentProofs == 0 {
+		s.maxConcurrentProofs = 1
+		if c, ok := controller.(capacityController); ok && c.Capacity() > 0 {
+			s.maxConcurrentProofs = c.Capacity()
+		}
+	}
+	s.cost.persist(disk)
+	s.breaker = newCircuitBreaker(s.breakerThreshold, s.breakerCooldown, s.chainId)
+	if c, ok := controller.(demandController); ok {
+		s.demandChanged = make(chan struct{}, 1)
+		go s.applyDemand(c)
+	}
+	if len(s.prewarm) != 0 {
+		go s.schedulePrewarm()
 	}
+	if source, ok := controller.(eventSource); ok {
+		source.Subscribe(s.onInstanceEvent)
+	}
+	return s
 }
 
-func (s *Service) Prove(traceString string) (*ProveResponse, error) {
-	id, blockNumber := computeId(traceString), readBlockNumber(traceString)
-	log.Printf("request prove for block number %s to prover", blockNumber)
+func (s *Service) Prove(traceString string, options ProveOptions) (*ProveResponse, error) {
+	trace, err := newTrace(traceString)
+	if err != nil {
+		return nil, err
+	}
+	defer trace.release()
+	return s.proveTrace(trace, options)
+}
+
+func (s *Service) proveTrace(trace *spooledTrace, options ProveOptions) (*ProveResponse, error) {
+	_, j, proof, err := s.submit(trace, options)
+	if err != nil {
+		return nil, err
+	}
+	if proof != nil {
+		return newProofResponseFromFileProof(proof)
+	}
+	log.Println("waiting proof generation.", "blockNumber:", j.blockNumber, "id:", j.id)
+	j.wg.Wait()
+	if j.err != nil {
+		return nil, j.err
+	}
+	return newProofResponseFromFileProof(j.proof)
+}
+
+// Result returns the stored proof of the job. It fails if the job is not completed.
+func (s *Service) Result(id string) (*ProveResponse, error) {
 	if proof := s.disk.Find(id); proof != nil {
 		return newProofResponseFromFileProof(proof)
 	}
+	if status := s.Status(id); status.State != JobUnknown {
+		return nil, NewJsonRpcErrorFromString(fmt.Sprintf("proof %s is %s", id, status.State))
+	}
+	return nil, NewJsonRpcErrorFromString(fmt.Sprintf("proof %s is not found", id))
+}
+
+// Submit queues the proof generation of the trace without waiting for it.
+func (s *Service) Submit(traceString string, options ProveOptions) (*JobStatus, error) {
+	trace, err := newTrace(traceString)
+	if err != nil {
+		return nil, err
+	}
+	defer trace.release()
+	return s.submitTrace(trace, options)
+}
+
+func (s *Service) submitTrace(trace *spooledTrace, options ProveOptions) (*JobStatus, error) {
+	id, _, _, err := s.submit(trace, options)
+	if err != nil {
+		return nil, err
+	}
+	return s.Status(id), nil
+}
+
+// submit returns the stored proof of the trace, or the job generating it. A new job takes the trace over.
+func (s *Service) submit(trace *spooledTrace, options ProveOptions) (string, *job, *FileProof, error) {
+	if err := s.checkCallback(options.CallbackUrl); err != nil {
+		return "", nil, nil, err
+	}
+	if err := trace.info.validate(s.disk.FindSpec()); err != nil {
+		return "", nil, nil, err
+	}
+	id, blockNumber := trace.id, trace.info.BlockNumber
+	log.Printf("request prove for block number %s to prover", blockNumber)
+	if proof := s.disk.Find(id); proof != nil {
+		if len(options.CallbackUrl) != 0 {
+			s.notify(id, blockNumber, []string{options.CallbackUrl}, proof, nil)
+		}
+		return id, nil, proof, nil
+	}
 	s.mu.Lock()
-	wg := s.inProgressProof[id]
-	if wg == nil {
-		var err error
-		wg, err = withClient(s, func(c ProverClient) (*sync.WaitGroup, error) {
-			wg := &sync.WaitGroup{}
-			wg.Add(1)
-			s.inProgressProof[id] = wg
-			go func(id, blockNumber string) {
-				defer wg.Done()
-				defer func() {
-					s.mu.Lock()
-					delete(s.inProgressProof, id)
-					s.mu.Unlock()
-					if len(s.inProgressProof) == 0 {
-						s.ec2.StopIfRunning()
-					}
-				}()
-				log.Println("prove start.", "blockNumber:", blockNumber, "id:", id)
-				res, err := c.Prove(traceString)
-				log.Println("prove complete.", "blockNumber:", blockNumber, "id:", id, "err:", err)
-				proof := &FileProof{}
-				if res != nil {
-					proof.FinalPair = res.FinalPair
-					proof.Proof = res.Proof
-				}
-				if err != nil {
-					proof.Error = err.Error()
-					proof.RpcError = NewJsonRpcErrorFromErrorOrNil(err)
-				}
-				s.disk.Save(id, proof)
-			}(id, blockNumber)
-			return wg, nil
-		})
-		if err != nil {
+	j := s.inProgressProof[id]
+	if j == nil {
+		if err := s.breaker.check(time.Now()); err != nil {
 			s.mu.Unlock()
-			return nil, err
+			return "", nil, nil, err
 		}
+		j = &job{id: id, blockNumber: blockNumber, trace: trace, options: options}
+		trace.owned = true
+		j.wg.Add(1)
+		s.inProgressProof[id] = j
+		s.enqueue(j)
+	} else {
+		s.escalate(j, options)
+	}
+	if len(options.CallbackUrl) != 0 {
+		j.callbacks = append(j.callbacks, options.CallbackUrl)
 	}
 	s.mu.Unlock()
-	log.Println("waiting proof generation.", "blockNumber:", blockNumber, "id:", id)
-	wg.Wait()
-	return newProofResponseFromFileProof(s.disk.Find(id))
+	s.setDemand()
+	return id, j, nil, nil
+}
+
+func (s *Service) run(j *job) {
+	defer j.wg.Done()
+	defer func() {
+		j.trace.data.remove()
+		s.mu.Lock()
+		delete(s.inProgressProof, j.id)
+		s.running--
+		s.dispatch()
+		s.mu.Unlock()
+		s.setDemand()
+		s.stopIfIdle()
+	}()
+	for {
+		if err := s.breaker.allow(j.id, time.Now()); err != nil {
+			s.failJob(j, err)
+			return
+		}
+		c, release, err := s.readyClient()
+		if err != nil {
+			s.breaker.failure(j.id, err, time.Now())
+			s.failJob(j, err)
+			return
+		}
+		interrupted := s.interruption()
+		region := s.region()
+		s.mu.Lock()
+		j.provingAt = time.Now()
+		s.transition(j, JobProving, nil)
+		s.mu.Unlock()
+		log.Println("prove start.", "blockNumber:", j.blockNumber, "id:", j.id)
+		type result struct {
+			res *ProveResponse
+			err error
+		}
+		done := make(chan result, 1)
+		ctx, cancel := context.WithCancel(s.closeContext)
+		go func() {
+			res, err := proveTrace(ctx, c, j.trace)
+			done <- result{res, err}
+		}()
+		select {
+		case r := <-done:
+			cancel()
+			release()
+			log.Println("prove complete.", "blockNumber:", j.blockNumber, "id:", j.id, "err:", r.err)
+			if r.err != nil {
+				s.breaker.failure(j.id, r.err, time.Now())
+			} else {
+				s.breaker.success(j.id)
+			}
+			proof := &FileProof{BlockNumber: j.blockNumber, Region: region}
+			if r.res != nil {
+				proof.FinalPair = r.res.FinalPair
+				proof.Proof = r.res.Proof
+			}
+			if r.err != nil {
+				proof.Error = r.err.Error()
+				proof.RpcError = NewJsonRpcErrorFromErrorOrNil(r.err)
+			}
+			proof.Cost = s.cost.finishJob(j.id, r.err == nil)
+			if r.err == nil {
+				s.mu.Lock()
+				s.recordProveDuration(time.Since(j.provingAt))
+				s.mu.Unlock()
+			}
+			if err := s.disk.Save(j.id, proof); err != nil {
+				// The proof cannot be served from the disk. Fail the job so that the next request proves it again.
+				log.Println(fmt.Errorf("failed to save proof %s: %w", j.id, err))
+				j.err = NewJsonRpcErrorFromString(fmt.Sprintf("failed to save proof: %v", err))
+				s.setState(j, JobFailed, j.err)
+				s.notify(j.id, j.blockNumber, s.jobWebhooks(j), nil, j.err)
+				return
+			}
+			j.proof = proof
+			if r.err != nil {
+				s.setState(j, JobFailed, r.err)
+			} else {
+				s.setState(j, JobDone, nil)
+			}
+			s.notify(j.id, j.blockNumber, s.jobWebhooks(j), proof, nil)
+			return
+		case <-interrupted:
+			// The request to the interrupted prover is aborted, so that it does not outlive the job.
+			cancel()
+			<-done
+			release()
+			s.mu.Lock()
+			j.provingAt = time.Time{}
+			s.transition(j, JobBooting, nil)
+			s.mu.Unlock()
+			log.Println("prover instance interrupted. requeue proof.", "blockNumber:", j.blockNumber, "id:", j.id)
+		}
+	}
+}
+
+// proveTrace sends the spooled trace to the prover.
+func proveTrace(ctx context.Context, c ProverClient, trace *spooledTrace) (*ProveResponse, error) {
+	reader, err := trace.data.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spooled trace: %w", err)
+	}
+	defer reader.Close()
+	return c.Prove(ctx, reader)
 }
 
-func (s *Service) Spec() (*ProverSpecResponse, error) {
+// failJob fails the job before the prover generates a proof.
+// Nothing is stored so that the next request retries.
+func (s *Service) failJob(j *job, err error) {
+	s.cost.finishJob(j.id, false)
+	j.err = err
+	s.setState(j, JobFailed, err)
+	s.notify(j.id, j.blockNumber, s.jobWebhooks(j), nil, err)
+}
+
+// Spec returns the cached spec of the prover. The spec is fetched from the prover
+// only if it is not cached yet or refresh is requested, which starts the instance.
+func (s *Service) Spec(refresh bool) (*SpecResponse, error) {
+	if !refresh {
+		if spec := s.disk.FindSpec(); spec != nil {
+			return spec, nil
+		}
+	}
 	log.Println("request spec to prover")
-	return withClient(s, func(c ProverClient) (*ProverSpecResponse, error) { return c.Spec() })
+	// The spec is refreshed while waiting for the prover server to run.
+	return withClient(s, func(c ProverClient) (*SpecResponse, error) { return s.disk.FindSpec(), nil })
+}
+
+func (s *Service) saveSpec(spec *ProverSpecResponse) {
+	s.disk.SaveSpec(&SpecResponse{ProverSpecResponse: *spec, FetchedAt: time.Now()})
+}
+
+// CostReport returns the cost of the proof with the given id, or the aggregate report if id is empty.
+func (s *Service) CostReport(id string) (any, error) {
+	if len(id) == 0 {
+		return s.cost.Report(), nil
+	}
+	proof := s.disk.Find(id)
+	if proof == nil {
+		return nil, fmt.Errorf("proof %s not found", id)
+	}
+	return &ProofCostReport{Id: id, Cost: proof.Cost}, nil
+}
+
+// checkCallback rejects a callback url whose host is not allowed.
+func (s *Service) checkCallback(callbackUrl string) error {
+	if len(callbackUrl) == 0 {
+		return nil
+	}
+	parsed, err := url.Parse(callbackUrl)
+	if err != nil {
+		return NewInvalidParamsError(fmt.Sprintf("invalid callbackUrl %s", callbackUrl), nil)
+	}
+	for _, host := range s.callbackHosts {
+		if strings.EqualFold(parsed.Hostname(), host) {
+			return nil
+		}
+	}
+	return NewInvalidParamsError(fmt.Sprintf("callbackUrl host %s is not allowed", parsed.Hostname()), s.callbackHosts)
+}
+
+func (s *Service) jobWebhooks(j *job) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(append([]string{}, j.callbacks...), s.webhookUrls...)
+}
+
+// notify enqueues the outcome of a job to the webhooks. err is set if the job failed without a proof.
+func (s *Service) notify(id, blockNumber string, urls []string, proof *FileProof, err error) {
+	if s.webhooks == nil || len(urls) == 0 {
+		return
+	}
+	payload := &WebhookPayload{Id: id, BlockNumber: blockNumber, Outcome: "success"}
+	if err == nil {
+		payload.Proof, err = newProofResponseFromFileProof(proof)
+	}
+	if err != nil {
+		payload.Outcome = "failure"
+		if payload.Error = NewJsonRpcErrorFromErrorOrNil(err); payload.Error == nil {
+			payload.Error = NewJsonRpcErrorFromString(err.Error())
+		}
+	}
+	if err := s.webhooks.Enqueue(urls, payload); err != nil {
+		log.Println(fmt.Errorf("failed to enqueue webhook of %s: %w", id, err))
+	}
+}
+
+func (s *Service) onInstanceEvent(event ec2.Event) {
+	s.cost.onEvent(event)
+	s.events.publish(TopicInstanceEvents, event)
 }
 
 func (s *Service) Close() {
+	s.close()
+	if c, ok := s.ec2.(cancelableController); ok {
+		c.CancelStart()
+	}
+	s.cost.flush()
 	s.disk.Close()
+	if s.webhooks != nil {
+		s.webhooks.Close()
+	}
 }
 
-func withClient[R interface{}](s *Service, callback func(c ProverClient) (*R, error)) (*R, error) {
-	defer func() {
-		if len(s.inProgressProof) == 0 {
-			log.Println("there is no proof in progress. shut down if it is running.")
-			s.ec2.StopIfRunning()
+func (s *Service) inProgressCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.inProgressProof)
+}
+
+func (s *Service) stopIfIdle() {
+	if s.inProgressCount() == 0 && !s.inPrewarmWindow(time.Now()) {
+		log.Println("there is no proof in progress. shut down if it is running.")
+		s.ec2.StopIfRunning()
+	}
+}
+
+// setDemand makes applyDemand set the current demand. It does not wait for the controller.
+func (s *Service) setDemand() {
+	select {
+	case s.demandChanged <- struct{}{}:
+	default:
+	}
+}
+
+// applyDemand sets the demand of the controller whenever it changes. The demand is read when it is applied, and
+// changes made meanwhile are coalesced into the next update, so that the controller always ends at the latest demand.
+func (s *Service) applyDemand(c demandController) {
+	for {
+		select {
+		case <-s.closeContext.Done():
+			return
+		case <-s.demandChanged:
 		}
-	}()
-	if err := s.ec2.StartIfNotRunning(); err != nil {
-		return nil, err
+		s.mu.Lock()
+		jobs := s.demand()
+		s.mu.Unlock()
+		c.SetDemand(jobs)
+	}
+}
+
+// interruption returns a channel closed when the current instance is interrupted,
+// or nil if the controller cannot be interrupted.
+func (s *Service) interruption() <-chan struct{} {
+	if c, ok := s.ec2.(interruptibleController); ok {
+		return c.Interruption()
 	}
-	client, err := NewProverClient(s.ec2.IpAddress())
+	return nil
+}
+
+func withClient[R interface{}](s *Service, callback func(c ProverClient) (*R, error)) (*R, error) {
+	defer s.stopIfIdle()
+	client, release, err := s.readyClient()
 	if err != nil {
 		return nil, err
 	}
-	for { // Wait for the prover server to run.
-		_, err := client.Spec()
+	defer release()
+	return callback(client)
+}
+
+// readyClient starts the instance if needed and waits for the prover server to run.
+// release must be called once the client is no longer used.
+func (s *Service) readyClient() (ProverClient, func(), error) {
+	for {
+		if err := s.ec2.StartIfNotRunning(); err != nil {
+			return nil, nil, err
+		}
+		interrupted := s.interruption()
+		failover, canFailover := s.ec2.(failoverController)
+		var readyTimeout time.Duration
+		var active int
+		if canFailover {
+			readyTimeout, active = failover.ReadyTimeout(), failover.Active()
+		}
+		address, release, err := s.lease()
+		if err != nil {
+			return nil, nil, err
+		}
+		client, err := NewProverClient(address)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		spec, err := waitForServer(client, interrupted, readyTimeout)
+		if errors.Is(err, errReadyTimeout) && canFailover {
+			release()
+			if !failover.Failover(active) {
+				return nil, nil, fmt.Errorf("prover server is not ready in any region: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		if spec != nil && s.chainId != 0 && spec.ChainId != 0 && uint64(spec.ChainId) != s.chainId {
+			// A misconfigured backend would prove the traces of its chain with the circuit of another chain.
+			release()
+			return nil, nil, fmt.Errorf("prover of chain %d serves chain %d", s.chainId, spec.ChainId)
+		}
+		if spec != nil {
+			s.saveSpec(spec)
+			return client, release, nil
+		}
+		release()
+		log.Println("prover instance interrupted while waiting for the server. restart.")
+	}
+}
+
+// region returns the region of the prover instance, or an empty string if the controller does not know it.
+func (s *Service) region() string {
+	if c, ok := s.ec2.(regionController); ok {
+		return c.Region()
+	}
+	return ""
+}
+
+func (s *Service) lease() (string, func(), error) {
+	if c, ok := s.ec2.(leasingController); ok {
+		return c.Lease()
+	}
+	return s.ec2.IpAddress(), func() {}, nil
+}
+
+var errReadyTimeout = errors.New("prover server is not ready in time")
+
+// waitForServer waits for the prover server to run and returns its spec.
+// It returns nil if the instance is interrupted meanwhile, and errReadyTimeout if timeout is positive and expires.
+func waitForServer(client ProverClient, interrupted <-chan struct{}, timeout time.Duration) (*ProverSpecResponse, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	for {
+		spec, err := client.Spec()
 		if err == nil {
-			break
+			return spec, nil
 		}
 		var urlError *url.Error
-		if errors.As(err, &urlError) {
-			log.Println("instance started. but server not ready. waiting...", "err", err)
-			time.Sleep(1 * time.Second)
-		} else {
+		if !errors.As(err, &urlError) {
 			// unexpected  error
 			return nil, err
 		}
+		log.Println("instance started. but server not ready. waiting...", "err", err)
+		select {
+		case <-interrupted:
+			return nil, nil
+		case <-expired:
+			return nil, errReadyTimeout
+		case <-time.After(1 * time.Second):
+		}
 	}
-	return callback(client)
 }
 
 func computeId(traceString string) string {
@@ -124,26 +651,6 @@ func computeId(traceString string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func readBlockNumber(traceString string) string {
-	result := make(map[string]interface{})
-	if err := json.Unmarshal([]byte(traceString), &result); err != nil {
-		log.Println("readBlockNumber: failed to json.Unmarshal", err)
-	}
-	if header, ok := result["header"]; ok {
-		if header, ok := header.(map[string]interface{}); ok {
-			if number, ok := header["number"].(string); ok {
-				return number
-			}
-			log.Println("readBlockNumber: blockNUmber does not string")
-			return ""
-		}
-		log.Println("readBlockNumber: header field does not object")
-		return ""
-	}
-	log.Println("readBlockNumber: header does not exist")
-	return ""
-}
-
 func newProofResponseFromFileProof(proof *FileProof) (*ProveResponse, error) {
 	if proof == nil {
 		return nil, errors.New("unexpected error")
@@ -0,0 +1,96 @@
+package proof
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// spoolThreshold is the size up to which spooled data is kept in memory.
+const spoolThreshold = 1 << 20
+
+// errSpoolFull is returned by a spool kept in memory when the data exceeds spoolThreshold.
+var errSpoolFull = errors.New("spooled data exceeds the memory threshold")
+
+// spool keeps the data written to it in memory up to spoolThreshold, and in a temporary file in dir beyond it.
+type spool struct {
+	dir    string
+	buffer []byte
+	file   *os.File
+	// name is the name of the temporary file. It is empty while the data is in memory.
+	name string
+	size int64
+	// memoryOnly keeps the data in memory. Writes beyond spoolThreshold fail with errSpoolFull.
+	memoryOnly bool
+}
+
+func newSpool(dir string) *spool {
+	return &spool{dir: dir}
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if len(s.name) == 0 && len(s.buffer)+len(p) > spoolThreshold {
+		if s.memoryOnly {
+			return 0, errSpoolFull
+		}
+		file, err := os.CreateTemp(s.dir, "trace-*.json")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create spool file: %w", err)
+		}
+		s.file, s.name = file, file.Name()
+		if _, err := file.Write(s.buffer); err != nil {
+			return 0, fmt.Errorf("failed to write spool file: %w", err)
+		}
+		s.buffer = nil
+	}
+	if len(s.name) == 0 {
+		s.buffer = append(s.buffer, p...)
+		s.size += int64(len(p))
+		return len(p), nil
+	}
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// finish closes the temporary file for writing.
+func (s *spool) finish() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// open returns a reader of the spooled data. It may be called several times after finish.
+func (s *spool) open() (io.ReadCloser, error) {
+	if len(s.name) == 0 {
+		return io.NopCloser(bytes.NewReader(s.buffer)), nil
+	}
+	return os.Open(s.name)
+}
+
+func (s *spool) String() (string, error) {
+	reader, err := s.open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	return string(data), err
+}
+
+// remove deletes the temporary file.
+func (s *spool) remove() {
+	_ = s.finish()
+	if len(s.name) == 0 {
+		return
+	}
+	if err := os.Remove(s.name); err != nil && !os.IsNotExist(err) {
+		log.Println(fmt.Errorf("failed to remove spool file %s: %w", s.name, err))
+	}
+}
@@ -0,0 +1,177 @@
+package proof
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// maxDurationHistory is the number of recent prove durations used to estimate ETAs.
+const maxDurationHistory = 20
+
+type JobState string
+
+const (
+	JobQueued  JobState = "queued"
+	JobBooting JobState = "booting"
+	JobProving JobState = "proving"
+	JobDone    JobState = "done"
+	JobFailed  JobState = "failed"
+	JobUnknown JobState = "unknown"
+)
+
+type JobStatus struct {
+	Id          string   `json:"id"`
+	BlockNumber string   `json:"blockNumber,omitempty"`
+	State       JobState `json:"state"`
+	Priority    Priority `json:"priority"`
+	// QueuePosition is the 1-based position of a queued job.
+	QueuePosition int        `json:"queuePosition,omitempty"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	// Eta is the expected completion time. It is omitted until a prove duration is known.
+	Eta *time.Time `json:"eta,omitempty"`
+}
+
+// Status returns the status of the job with the given id.
+func (s *Service) Status(id string) *JobStatus {
+	var status *JobStatus
+	s.mu.Lock()
+	if j := s.inProgressProof[id]; j != nil {
+		for _, jobStatus := range s.statuses() {
+			if jobStatus.Id == id {
+				status = jobStatus
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	if status != nil {
+		return status
+	}
+	// The job is completed.
+	status = &JobStatus{Id: id, State: JobUnknown}
+	if meta := s.disk.Meta(id); meta != nil {
+		status.BlockNumber = meta.BlockNumber
+		status.State = JobDone
+		if meta.Failed {
+			status.State = JobFailed
+		}
+	}
+	return status
+}
+
+// QueueStatus returns the status of the running and queued jobs in dispatch order.
+func (s *Service) QueueStatus() []*JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statuses()
+}
+
+// statuses returns the status of the running and queued jobs in dispatch order. s.mu must be held.
+func (s *Service) statuses() []*JobStatus {
+	now := time.Now()
+	duration := s.averageProveDuration()
+	slots := &timeHeap{}
+	var result []*JobStatus
+	for _, j := range s.inProgressProof {
+		if j.index >= 0 {
+			continue
+		}
+		status := j.status()
+		if duration != 0 {
+			// A booting job is expected to start proving now.
+			eta := now.Add(duration)
+			if !j.provingAt.IsZero() {
+				eta = j.provingAt.Add(duration)
+			}
+			if eta.Before(now) {
+				eta = now
+			}
+			status.Eta = &eta
+			heap.Push(slots, eta)
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].StartedAt.Before(*result[b].StartedAt) })
+	concurrency := s.maxConcurrentProofs
+	if concurrency <= 0 {
+		// Every job runs at once.
+		concurrency = len(s.inProgressProof)
+	}
+	for slots.Len() < concurrency {
+		heap.Push(slots, now)
+	}
+	queue := append(jobQueue{}, s.queue...)
+	sort.Slice(queue, queue.Less)
+	for i, j := range queue {
+		status := j.status()
+		status.QueuePosition = i + 1
+		if duration != 0 {
+			// The job starts when the earliest slot is free.
+			eta := heap.Pop(slots).(time.Time).Add(duration)
+			status.Eta = &eta
+			heap.Push(slots, eta)
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+func (j *job) status() *JobStatus {
+	status := &JobStatus{Id: j.id, BlockNumber: j.blockNumber, State: j.state, Priority: j.options.Priority}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		status.StartedAt = &startedAt
+	}
+	return status
+}
+
+// setState changes the state of the job. err is the cause of a failure.
+func (s *Service) setState(j *job, state JobState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transition(j, state, err)
+}
+
+// transition changes the state of the job and publishes it as a job event. s.mu must be held.
+func (s *Service) transition(j *job, state JobState, err error) {
+	j.state = state
+	event := &JobEvent{ChainId: s.chainId, Id: j.id, BlockNumber: j.blockNumber, State: state, Time: time.Now()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	s.events.publish(TopicJobEvents, event)
+}
+
+// recordProveDuration keeps the proving time of a successful job, without the boot of the instance. s.mu must be held.
+func (s *Service) recordProveDuration(duration time.Duration) {
+	s.durations = append(s.durations, duration)
+	if len(s.durations) > maxDurationHistory {
+		s.durations = s.durations[len(s.durations)-maxDurationHistory:]
+	}
+}
+
+// averageProveDuration returns zero if no job is completed yet. s.mu must be held.
+func (s *Service) averageProveDuration() time.Duration {
+	if len(s.durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, duration := range s.durations {
+		sum += duration
+	}
+	return sum / time.Duration(len(s.durations))
+}
+
+type timeHeap []time.Time
+
+func (h timeHeap) Len() int           { return len(h) }
+func (h timeHeap) Less(i, j int) bool { return h[i].Before(h[j]) }
+func (h timeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *timeHeap) Push(x any)        { *h = append(*h, x.(time.Time)) }
+func (h *timeHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
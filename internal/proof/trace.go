@@ -0,0 +1,240 @@
+package proof
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+type traceInfo struct {
+	BlockNumber  string
+	ChainId      uint64
+	TxCount      int
+	CallDataSize int
+}
+
+// spooledTrace is a submitted trace with its id and the fields read by the proxy.
+type spooledTrace struct {
+	id   string
+	info *traceInfo
+	data *spool
+	// owned is set when a job takes the trace over. The job removes the spooled data when it completes.
+	owned bool
+}
+
+// newTrace reads the fields of a trace given as a string.
+func newTrace(traceString string) (*spooledTrace, error) {
+	writer := newTraceWriter("")
+	if _, err := io.WriteString(writer, traceString); err != nil {
+		writer.abort()
+		return nil, err
+	}
+	trace, err := writer.close()
+	if err != nil {
+		trace.release()
+		return nil, NewInvalidParamsError(err.Error(), nil)
+	}
+	return trace, nil
+}
+
+// release removes the spooled data unless a job owns it.
+func (t *spooledTrace) release() {
+	if !t.owned {
+		t.data.remove()
+	}
+}
+
+// traceWriter spools the trace written to it. The trace is hashed and its fields are read at the same time,
+// so that the trace is read only once.
+type traceWriter struct {
+	data   *spool
+	hash   hash.Hash
+	pipe   *io.PipeWriter
+	parsed chan traceResult
+}
+
+type traceResult struct {
+	info *traceInfo
+	err  error
+}
+
+func newTraceWriter(dir string) *traceWriter {
+	reader, writer := io.Pipe()
+	w := &traceWriter{data: newSpool(dir), hash: md5.New(), pipe: writer, parsed: make(chan traceResult, 1)}
+	go func() {
+		info, err := scanTrace(reader)
+		// The rest of the trace is drained so that writes do not block.
+		_, _ = io.Copy(io.Discard, reader)
+		w.parsed <- traceResult{info, err}
+	}()
+	return w
+}
+
+func (w *traceWriter) Write(p []byte) (int, error) {
+	if _, err := w.data.Write(p); err != nil {
+		return 0, err
+	}
+	w.hash.Write(p)
+	return w.pipe.Write(p)
+}
+
+// close returns the written trace. The trace is returned with an error if its fields cannot be read.
+func (w *traceWriter) close() (*spooledTrace, error) {
+	_ = w.pipe.Close()
+	result := <-w.parsed
+	trace := &spooledTrace{id: hex.EncodeToString(w.hash.Sum(nil)), info: result.info, data: w.data}
+	if err := w.data.finish(); err != nil {
+		return trace, fmt.Errorf("failed to write spool file: %w", err)
+	}
+	return trace, result.err
+}
+
+// abort discards the written trace.
+func (w *traceWriter) abort() {
+	_ = w.pipe.CloseWithError(errors.New("trace is aborted"))
+	<-w.parsed
+	w.data.remove()
+}
+
+// scanTrace reads the block number and the size of the block from the trace, token by token.
+// Unlike json.Unmarshal, it never holds more than a single value of the trace.
+func scanTrace(reader io.Reader) (*traceInfo, error) {
+	decoder := json.NewDecoder(reader)
+	info := &traceInfo{}
+	var number json.Token
+	hasHeader, hasNumber := false, false
+	err := scanObject(decoder, func(key string) error {
+		switch key {
+		case "chainID":
+			return decoder.Decode(&info.ChainId)
+		case "header":
+			hasHeader = true
+			return scanObject(decoder, func(key string) error {
+				if key != "number" {
+					return skipValue(decoder)
+				}
+				hasNumber = true
+				var err error
+				number, err = decoder.Token()
+				return err
+			})
+		case "transactions":
+			return scanArray(decoder, func() error {
+				info.TxCount++
+				return scanObject(decoder, func(key string) error {
+					if key != "data" {
+						return skipValue(decoder)
+					}
+					var data string
+					if err := decoder.Decode(&data); err != nil {
+						return err
+					}
+					info.CallDataSize += len(strings.TrimPrefix(data, "0x")) / 2
+					return nil
+				})
+			})
+		default:
+			return skipValue(decoder)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trace: %w", err)
+	}
+	if !hasHeader {
+		return nil, errors.New("header does not exist")
+	}
+	if !hasNumber {
+		return nil, errors.New("header.number does not exist")
+	}
+	if blockNumber, ok := number.(string); ok && len(blockNumber) != 0 {
+		info.BlockNumber = blockNumber
+		return info, nil
+	}
+	return nil, errors.New("header.number is not a string")
+}
+
+// scanObject calls fn with the key of each member of the object. fn must read the value. A null object has no members.
+func scanObject(decoder *json.Decoder, fn func(key string) error) error {
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("expected an object, but got %v", token)
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if err := fn(key.(string)); err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// scanArray calls fn for each element of the array. fn must read the element. A null array has no elements.
+func scanArray(decoder *json.Decoder, fn func() error) error {
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected an array, but got %v", token)
+	}
+	for decoder.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// skipValue reads the next value token by token.
+func skipValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// validate checks the trace against the capacity of the prover.
+func (t *traceInfo) validate(spec *SpecResponse) error {
+	if spec == nil {
+		return nil
+	}
+	var violations []string
+	if spec.ChainId != 0 && t.ChainId != 0 && uint64(spec.ChainId) != t.ChainId {
+		violations = append(violations, fmt.Sprintf("chain id %d does not match prover chain id %d", t.ChainId, spec.ChainId))
+	}
+	if spec.MaxTxs != 0 && t.TxCount > int(spec.MaxTxs) {
+		violations = append(violations, fmt.Sprintf("transaction count %d exceeds max txs %d", t.TxCount, spec.MaxTxs))
+	}
+	if spec.MaxCallData != 0 && t.CallDataSize > int(spec.MaxCallData) {
+		violations = append(violations, fmt.Sprintf("call data size %d exceeds max call data %d", t.CallDataSize, spec.MaxCallData))
+	}
+	if len(violations) != 0 {
+		return NewInvalidParamsError("invalid trace: "+strings.Join(violations, ", "), violations)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package proof
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScanTrace(t *testing.T) {
+	trace, err := scanTrace(strings.NewReader(`{"chainID":255,"header":{"number":"0x10"},"transactions":[{"data":"0x0102"},{"data":"0x"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace.BlockNumber != "0x10" || trace.ChainId != 255 || trace.TxCount != 2 || trace.CallDataSize != 2 {
+		t.Errorf("trace mismatch. got %+v", trace)
+	}
+	for _, invalid := range []string{`{`, `{"chainID":255}`, `{"header":{}}`, `{"header":{"number":16}}`} {
+		if _, err := scanTrace(strings.NewReader(invalid)); err == nil {
+			t.Errorf("invalid trace %s must be rejected", invalid)
+		}
+	}
+}
+
+func TestValidateTrace(t *testing.T) {
+	spec := &SpecResponse{ProverSpecResponse: ProverSpecResponse{ChainId: 255, MaxTxs: 1, MaxCallData: 10}}
+	valid := &traceInfo{BlockNumber: "0x10", ChainId: 255, TxCount: 1, CallDataSize: 10}
+	if err := valid.validate(spec); err != nil {
+		t.Errorf("valid trace rejected: %v", err)
+	}
+	invalid := &traceInfo{BlockNumber: "0x10", ChainId: 1, TxCount: 2, CallDataSize: 11}
+	var rpcError *JsonRpcError
+	if err := invalid.validate(spec); !errors.As(err, &rpcError) || rpcError.Code != -32602 {
+		t.Fatalf("invalid trace must be rejected with -32602. got %v", err)
+	}
+	if violations := rpcError.Data.([]string); len(violations) != 3 {
+		t.Errorf("violation count mismatch. expected 3, but got %v", violations)
+	}
+	if err := invalid.validate(nil); err != nil {
+		t.Errorf("trace must not be validated without spec: %v", err)
+	}
+}
@@ -1,5 +1,7 @@
 package proof
 
+import "time"
+
 type (
 	ProveResponse struct {
 		FinalPair []byte `json:"final_pair,omitempty"`
@@ -13,4 +15,19 @@ type (
 		MaxTxs      uint32 `json:"max_txs,omitempty"`
 		MaxCallData uint32 `json:"max_call_data,omitempty"`
 	}
+
+	// SpecResponse is the cached spec of the prover.
+	SpecResponse struct {
+		ProverSpecResponse
+		FetchedAt time.Time `json:"fetched_at"`
+	}
+
+	// WebhookPayload is posted to the webhooks when a proof is completed.
+	WebhookPayload struct {
+		Id          string         `json:"id"`
+		BlockNumber string         `json:"blockNumber"`
+		Outcome     string         `json:"outcome"`
+		Proof       *ProveResponse `json:"proof,omitempty"`
+		Error       *JsonRpcError  `json:"error,omitempty"`
+	}
 )
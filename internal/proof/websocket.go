@@ -0,0 +1,167 @@
+package proof
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConnection serves json rpc over a websocket with eth_subscribe style subscriptions:
+// proxy_subscribe returns a subscription id, and events are sent as proxy_subscription notifications.
+type wsConnection struct {
+	server  *Server
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	// subscriptions has a subscription to the feed of each backend per subscription id.
+	subscriptions map[string][]*subscription
+}
+
+func (s *Server) serveWebSocket(writer http.ResponseWriter, httpRequest *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(writer, httpRequest, nil)
+	if err != nil {
+		log.Println(fmt.Errorf("failed to upgrade websocket: %w", err))
+		return
+	}
+	if s.maxRequestSize > 0 {
+		conn.SetReadLimit(s.maxRequestSize)
+	}
+	caller := s.callerOf(httpRequest)
+	c := &wsConnection{server: s, conn: conn, subscriptions: make(map[string][]*subscription)}
+	defer c.close()
+	for {
+		_, reader, err := conn.NextReader()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println(fmt.Errorf("failed to read websocket request: %w", err))
+			}
+			return
+		}
+		// The trace is streamed into the spool like the trace of an http request.
+		request, err := s.readCall(reader, caller.client)
+		if rpcError := NewJsonRpcErrorFromErrorOrNil(err); rpcError != nil {
+			c.write(newResponse(nil, nil, err))
+			continue
+		}
+		if err != nil {
+			log.Println(fmt.Errorf("failed to read websocket request: %w", err))
+			return
+		}
+		params, err := request.callParams()
+		if err != nil {
+			request.release()
+			log.Println(fmt.Errorf("failed to read spooled params: %w", err))
+			return
+		}
+		switch request.method {
+		case "proxy_subscribe":
+			topic, _ := stringParam(params, 0)
+			result, err := c.subscribe(topic)
+			c.write(newResponse(request.id, result, err))
+			request.release()
+		case "proxy_unsubscribe":
+			id, _ := stringParam(params, 0)
+			c.write(newResponse(request.id, c.unsubscribe(id), nil))
+			request.release()
+		default:
+			// Methods like prove take long. They must not block the subscriptions.
+			go func(request *rpcRequest) {
+				defer request.release()
+				result, err := s.call(caller, request.method, params)
+				c.write(newResponse(request.id, result, err))
+			}(request)
+		}
+	}
+}
+
+// checkOrigin accepts a websocket from the same origin or an allowed origin, so that other web pages cannot use
+// the proxy through the browser of a visitor. A request without Origin is not from a browser and is accepted.
+func (s *Server) checkOrigin(httpRequest *http.Request) bool {
+	origin := httpRequest.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	for _, allowed := range s.wsOrigins {
+		if strings.EqualFold(origin, strings.TrimSuffix(allowed, "/")) {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, httpRequest.Host)
+}
+
+func (c *wsConnection) subscribe(topic string) (string, error) {
+	if topic != TopicJobEvents && topic != TopicInstanceEvents {
+		return "", NewInvalidParamsError(fmt.Sprintf("unsupported subscription %s", topic), []string{TopicJobEvents, TopicInstanceEvents})
+	}
+	idBytes := make([]byte, 16)
+	_, _ = rand.Read(idBytes)
+	id := "0x" + hex.EncodeToString(idBytes)
+	var subs []*subscription
+	for _, service := range c.server.services() {
+		subs = append(subs, service.events.subscribe(topic))
+	}
+	c.mu.Lock()
+	c.subscriptions[id] = subs
+	c.mu.Unlock()
+	for _, sub := range subs {
+		go func(sub *subscription) {
+			for event := range sub.events {
+				c.write(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"method":  "proxy_subscription",
+					"params": map[string]interface{}{
+						"subscription": id,
+						"result":       event,
+					},
+				})
+			}
+		}(sub)
+	}
+	return id, nil
+}
+
+func (c *wsConnection) unsubscribe(id string) bool {
+	c.mu.Lock()
+	subs, ok := c.subscriptions[id]
+	delete(c.subscriptions, id)
+	c.mu.Unlock()
+	c.server.unsubscribe(subs)
+	return ok
+}
+
+func (c *wsConnection) write(message any) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.WriteJSON(message); err != nil {
+		log.Println(fmt.Errorf("failed to write websocket message: %w", err))
+	}
+}
+
+func (c *wsConnection) close() {
+	c.mu.Lock()
+	subscriptions := c.subscriptions
+	c.subscriptions = make(map[string][]*subscription)
+	c.mu.Unlock()
+	for _, subs := range subscriptions {
+		c.server.unsubscribe(subs)
+	}
+	_ = c.conn.Close()
+}
+
+// unsubscribe removes the subscriptions made to the feed of each backend in services order.
+func (s *Server) unsubscribe(subs []*subscription) {
+	for i, service := range s.services() {
+		if i < len(subs) {
+			service.events.unsubscribe(subs[i])
+		}
+	}
+}
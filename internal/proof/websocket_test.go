@@ -0,0 +1,98 @@
+package proof
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketSubscription(t *testing.T) {
+	server := NewServer(&Service{events: newEventFeed()})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var response map[string]interface{}
+	_ = conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "proxy_subscribe", "params": []any{TopicJobEvents}})
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatal(err)
+	}
+	subscriptionId, ok := response["result"].(string)
+	if !ok {
+		t.Fatalf("subscription id not returned. got %v", response)
+	}
+
+	server.service.transition(&job{id: "0", blockNumber: "0x1"}, JobQueued, nil)
+	var notification struct {
+		Method string `json:"method"`
+		Params struct {
+			Subscription string   `json:"subscription"`
+			Result       JobEvent `json:"result"`
+		} `json:"params"`
+	}
+	if err := conn.ReadJSON(&notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification.Method != "proxy_subscription" || notification.Params.Subscription != subscriptionId {
+		t.Errorf("notification mismatch. got %+v", notification)
+	}
+	if event := notification.Params.Result; event.Id != "0" || event.State != JobQueued {
+		t.Errorf("job event mismatch. got %+v", event)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	server := NewServer(&Service{events: newEventFeed()}, WithWebSocketOrigins([]string{"https://app.example.com/"}))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+	for origin, allowed := range map[string]bool{
+		"":                         true,
+		"https://app.example.com":  true,
+		httpServer.URL:             true,
+		"https://evil.example.com": false,
+	} {
+		header := http.Header{}
+		if len(origin) != 0 {
+			header.Set("Origin", origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if (err == nil) != allowed {
+			t.Errorf("websocket from origin %q must be allowed: %v. got %v", origin, allowed, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestWebSocketProveSubmit(t *testing.T) {
+	service := &Service{disk: newTestDiskRepository(t), events: newEventFeed(), inProgressProof: make(map[string]*job)}
+	server := NewServer(service, WithSpoolDir(t.TempDir()))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The trace is larger than the memory threshold, so that it is spooled to the spool dir.
+	trace := `{"header":{"number":"0x10"},"padding":"` + strings.Repeat("0", 2*spoolThreshold) + `"}`
+	service.disk.Save(computeId(trace), &FileProof{BlockNumber: "0x10", Proof: []byte("proof")})
+
+	var response struct{ Result JobStatus }
+	_ = conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "prove_submit", "params": []any{trace}})
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Result.Id != computeId(trace) || response.Result.State != JobDone {
+		t.Errorf("cached proof of the streamed trace must be found. got %+v", response.Result)
+	}
+}
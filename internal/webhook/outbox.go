@@ -0,0 +1,289 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader is the HMAC-SHA256 of the timestamp, a dot and the payload, signed with the secret.
+	SignatureHeader = "X-Signature-256"
+	// TimestampHeader is the unix time of the attempt. Receivers should reject old timestamps, so that a captured
+	// delivery cannot be replayed.
+	TimestampHeader  = "X-Signature-Timestamp"
+	DeliveryIdHeader = "X-Delivery-Id"
+
+	maxAttempts = 15
+	maxBackoff  = 1 * time.Hour
+	deadDir     = "dead"
+)
+
+// Delivery is a pending webhook call persisted in the outbox.
+type Delivery struct {
+	Id          string          `json:"id"`
+	Url         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// errNoSecret is returned by Enqueue if the secret is not configured. Webhooks are never sent unsigned.
+var errNoSecret = errors.New("webhook secret is not configured")
+
+// Outbox persists webhook deliveries in a directory and retries them with exponential backoff
+// until the receiver responds with 2xx. Deliveries failing maxAttempts times are moved to the dead directory.
+// Each url is delivered to by its own goroutine, so that a slow receiver does not delay the others.
+type Outbox struct {
+	dir          string
+	secret       []byte
+	client       *http.Client
+	wake         chan struct{}
+	closeContext context.Context
+	Close        context.CancelFunc
+	mu           sync.Mutex
+	// busy are the urls being delivered to.
+	busy map[string]bool
+	// workers are the running deliveries.
+	workers sync.WaitGroup
+}
+
+func NewOutbox(dir string, secret string) *Outbox {
+	if err := os.MkdirAll(filepath.Join(dir, deadDir), 0777); err != nil {
+		log.Panicln(fmt.Errorf("os.MkdirAll failed: %w", err))
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	outbox := &Outbox{
+		dir:    dir,
+		secret: []byte(secret),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// A redirect is not followed, so that a receiver cannot send a delivery to another host.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		wake:         make(chan struct{}, 1),
+		closeContext: ctx,
+		Close:        cancelFunc,
+		busy:         make(map[string]bool),
+	}
+	go outbox.deliverLoop()
+	return outbox
+}
+
+// Enqueue stores a delivery of payload for each url.
+func (o *Outbox) Enqueue(urls []string, payload any) error {
+	if len(o.secret) == 0 {
+		return errNoSecret
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to json.Marshal webhook payload: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, url := range urls {
+		if len(url) == 0 || seen[url] {
+			continue
+		}
+		seen[url] = true
+		delivery := &Delivery{Id: newDeliveryId(), Url: url, Payload: body, NextAttempt: time.Now()}
+		if err := o.save(delivery); err != nil {
+			return err
+		}
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (o *Outbox) deliverLoop() {
+	for {
+		next := o.deliverDue(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-o.wake:
+			timer.Stop()
+		case <-o.closeContext.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// deliverDue starts the deliveries due at now to the urls which are not being delivered to, and returns when the
+// next one is due. A worker wakes the loop when it finishes, so that its failed deliveries are scheduled.
+func (o *Outbox) deliverDue(now time.Time) time.Time {
+	next := now.Add(1 * time.Minute)
+	files, _ := os.ReadDir(o.dir)
+	due := make(map[string][]*Delivery)
+	var urls []string
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		delivery, err := o.load(file.Name())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if o.busy[delivery.Url] {
+			continue
+		}
+		if delivery.NextAttempt.After(now) {
+			if delivery.NextAttempt.Before(next) {
+				next = delivery.NextAttempt
+			}
+			continue
+		}
+		if _, ok := due[delivery.Url]; !ok {
+			urls = append(urls, delivery.Url)
+		}
+		due[delivery.Url] = append(due[delivery.Url], delivery)
+	}
+	for _, url := range urls {
+		o.busy[url] = true
+		o.workers.Add(1)
+		go o.deliverAll(url, due[url])
+	}
+	return next
+}
+
+// deliverAll delivers the deliveries to url in order.
+func (o *Outbox) deliverAll(url string, deliveries []*Delivery) {
+	defer o.workers.Done()
+	for _, delivery := range deliveries {
+		if o.closeContext.Err() != nil {
+			break
+		}
+		o.attempt(delivery)
+	}
+	o.mu.Lock()
+	delete(o.busy, url)
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// attempt delivers the delivery once. A failed delivery is retried after a backoff, or moved to the dead directory.
+func (o *Outbox) attempt(delivery *Delivery) {
+	name := delivery.Id + ".json"
+	err := o.deliver(delivery)
+	if err == nil {
+		_ = os.Remove(filepath.Join(o.dir, name))
+		return
+	}
+	if o.closeContext.Err() != nil {
+		// The attempt is aborted by Close. It does not count.
+		return
+	}
+	delivery.LastError = err.Error()
+	delivery.Attempts++
+	if delivery.Attempts >= maxAttempts {
+		log.Printf("webhook delivery %s to %s failed %d times. give up: %s", delivery.Id, delivery.Url, delivery.Attempts, delivery.LastError)
+		if err := os.Rename(filepath.Join(o.dir, name), filepath.Join(o.dir, deadDir, name)); err != nil {
+			log.Println(fmt.Errorf("failed to move dead webhook delivery %s: %w", delivery.Id, err))
+		}
+		return
+	}
+	delivery.NextAttempt = time.Now().Add(backoff(delivery.Attempts))
+	log.Printf("webhook delivery %s to %s failed (attempts: %d): %s", delivery.Id, delivery.Url, delivery.Attempts, delivery.LastError)
+	if err := o.save(delivery); err != nil {
+		log.Println(err)
+	}
+}
+
+func (o *Outbox) deliver(delivery *Delivery) error {
+	if len(o.secret) == 0 {
+		return errNoSecret
+	}
+	request, err := http.NewRequestWithContext(o.closeContext, http.MethodPost, delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(DeliveryIdHeader, delivery.Id)
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, "sha256="+Sign(o.secret, timestamp, delivery.Payload))
+	response, err := o.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func (o *Outbox) load(name string) (*Delivery, error) {
+	file, err := os.ReadFile(filepath.Join(o.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook delivery %s: %w", name, err)
+	}
+	var delivery Delivery
+	if err := json.Unmarshal(file, &delivery); err != nil {
+		return nil, fmt.Errorf("failed to json.Unmarshal webhook delivery %s: %w", name, err)
+	}
+	return &delivery, nil
+}
+
+// save writes the delivery to a temporary file and renames it so that the loop never reads a partial file.
+func (o *Outbox) save(delivery *Delivery) error {
+	jsonBytes, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to json.Marshal webhook delivery: %w", err)
+	}
+	path := filepath.Join(o.dir, delivery.Id+".json")
+	if err := os.WriteFile(path+".tmp", jsonBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write webhook delivery: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp in unix seconds, a dot and body.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func backoff(attempts int) time.Duration {
+	if attempts >= 12 {
+		return maxBackoff
+	}
+	if duration := time.Duration(1<<attempts) * time.Second; duration < maxBackoff {
+		return duration
+	}
+	return maxBackoff
+}
+
+func newDeliveryId() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestOutbox(t *testing.T, secret []byte, client *http.Client) *Outbox {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &Outbox{dir: t.TempDir(), secret: secret, client: client, wake: make(chan struct{}, 1), closeContext: ctx, Close: cancel, busy: make(map[string]bool)}
+}
+
+func TestOutboxRetryUntilDelivered(t *testing.T) {
+	secret := []byte("secret")
+	var calls int
+	var signature, timestamp, body string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		if calls == 1 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		payload, _ := io.ReadAll(request.Body)
+		signature, timestamp, body = request.Header.Get(SignatureHeader), request.Header.Get(TimestampHeader), string(payload)
+	}))
+	defer server.Close()
+	outbox := newTestOutbox(t, secret, server.Client())
+
+	if err := outbox.Enqueue([]string{server.URL, server.URL}, map[string]string{"id": "0"}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if next := outbox.deliverDue(now); !next.After(now) {
+		t.Errorf("failed delivery must be retried later. next %v", next)
+	}
+	outbox.workers.Wait()
+	if files, _ := os.ReadDir(outbox.dir); len(files) != 1 {
+		t.Fatalf("failed delivery must be kept in the outbox. got %d files", len(files))
+	}
+	outbox.deliverDue(now.Add(maxBackoff))
+	outbox.workers.Wait()
+	if calls != 2 {
+		t.Errorf("call count mismatch. expected 2, but got %d", calls)
+	}
+	unix, _ := strconv.ParseInt(timestamp, 10, 64)
+	if body != `{"id":"0"}` || signature != "sha256="+Sign(secret, unix, []byte(body)) || time.Since(time.Unix(unix, 0)) > time.Minute {
+		t.Errorf("payload mismatch. body %s, timestamp %s, signature %s", body, timestamp, signature)
+	}
+	if files, _ := os.ReadDir(outbox.dir); len(files) != 0 {
+		t.Errorf("delivered webhook must be removed. got %d files", len(files))
+	}
+}
+
+func TestOutboxDeliversEachUrlIndependently(t *testing.T) {
+	blocked := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-blocked }))
+	defer slow.Close()
+	defer close(blocked)
+	delivered := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { delivered <- struct{}{} }))
+	defer fast.Close()
+	outbox := newTestOutbox(t, []byte("secret"), http.DefaultClient)
+
+	if err := outbox.Enqueue([]string{slow.URL, fast.URL}, map[string]string{"id": "0"}); err != nil {
+		t.Fatal(err)
+	}
+	outbox.deliverDue(time.Now())
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow receiver must not delay the delivery to another url")
+	}
+}
+
+func TestOutboxRequiresSecret(t *testing.T) {
+	outbox := newTestOutbox(t, nil, http.DefaultClient)
+	if err := outbox.Enqueue([]string{"http://localhost/hook"}, map[string]string{"id": "0"}); err == nil {
+		t.Errorf("webhook must not be enqueued without a secret")
+	}
+}